@@ -5,8 +5,6 @@ import (
 	"strconv"
 
 	"github.com/gothyra/thyra/game"
-
-	"github.com/jpillora/ansi"
 )
 
 type Area struct {
@@ -19,17 +17,43 @@ type Room struct {
 	Name        string `toml:"name"`
 	Description string `toml:"description"`
 	Cubes       []Cube `toml:"cubes"`
+	NPCs        []NPC  `toml:"npcs"`
+}
+
+// NPC is a character that is not played by anyone. It stands on a cube of the
+// room it is listed in.
+type NPC struct {
+	Name     string `toml:"name"`
+	Position string `toml:"position"`
+	// Actions are what the NPC does now and then, shown to the room after
+	// its name, such as "sniffs the air.".
+	Actions []string `toml:"actions"`
+	// Wander lets the NPC walk to the cubes around it, within its room.
+	Wander bool `toml:"wander"`
+	game.PC
 }
 
 // Player holds all variables for a character.
 type Player struct {
 	Nickname string `toml:"nickname"`
 	game.PC
+	// Damage is how many hit points the player lost. It heals over time.
+	Damage       int    `toml:"damage"`
 	Area         string `toml:"area"`
 	Room         string `toml:"room"`
 	Position     string `toml:"position"`
 	PreviousRoom string `toml:"previousRoom"`
 	PreviousArea string `toml:"previousArea"`
+	// Layout is the name of the screen layout the player picked.
+	Layout string `toml:"layout"`
+	// Ignored holds the nicknames of the players whose chat is not shown.
+	Ignored []string `toml:"ignored"`
+	// SHA256 fingerprints of the SSH public keys allowed to log in as this player.
+	KeyFingerprints []string `toml:"keyFingerprints"`
+	// bcrypt hash of the password allowed to log in as this player.
+	PasswordHash string `toml:"passwordHash"`
+	// Guest players are anonymous and never saved.
+	Guest bool `toml:"-"`
 }
 
 type Cube struct {
@@ -40,136 +64,228 @@ type Cube struct {
 	Type  string `toml:"type"`
 }
 
+// Exit is where a door leads. Exits of other cubes need a direction, such as
+// "up" for stairs. They may leave out the area and room when they lead to the
+// same area or room.
 type Exit struct {
-	ToArea   string `toml:"toarea"`
-	ToRoom   string `toml:"toroom"`
-	ToCubeID string `toml:"tocubeid"`
+	Direction string `toml:"direction"`
+	ToArea    string `toml:"toarea"`
+	ToRoom    string `toml:"toroom"`
+	ToCubeID  string `toml:"tocubeid"`
+}
+
+// Direction is a way players can move from a cube.
+type Direction int
+
+const (
+	East Direction = iota
+	West
+	North
+	South
+	NorthEast
+	NorthWest
+	SouthEast
+	SouthWest
+	Up
+	Down
+)
+
+// Directions lists all directions in the order exits are shown.
+var Directions = []Direction{North, NorthEast, East, SouthEast, South, SouthWest, West, NorthWest, Up, Down}
+
+var directionNames = map[Direction]string{
+	East:      "east",
+	West:      "west",
+	North:     "north",
+	South:     "south",
+	NorthEast: "northeast",
+	NorthWest: "northwest",
+	SouthEast: "southeast",
+	SouthWest: "southwest",
+	Up:        "up",
+	Down:      "down",
+}
+
+func (d Direction) String() string {
+	return directionNames[d]
+}
+
+// ParseDirection returns the direction with the given name, as used for the
+// direction of an exit in the area files.
+func ParseDirection(name string) (Direction, bool) {
+	for d, n := range directionNames {
+		if n == name {
+			return d, true
+		}
+	}
+	return 0, false
 }
 
-// Find available moves
-func FindExits(s [][]Cube, area, room, pos string) [][]string {
+// directionSigns are what the exits panel shows for each direction.
+var directionSigns = map[Direction]string{
+	East:      "→",
+	West:      "←",
+	North:     "↑",
+	South:     "↓",
+	NorthEast: "↗",
+	NorthWest: "↖",
+	SouthEast: "↘",
+	SouthWest: "↙",
+	Up:        "up",
+	Down:      "down",
+}
+
+// directionOffsets are how far a step in a direction goes on the room grid.
+// Up and down only lead somewhere through the exits of a cube.
+var directionOffsets = map[Direction][2]int{
+	East:      {1, 0},
+	West:      {-1, 0},
+	North:     {0, -1},
+	South:     {0, 1},
+	NorthEast: {1, -1},
+	NorthWest: {-1, -1},
+	SouthEast: {1, 1},
+	SouthWest: {-1, 1},
+}
+
+// Destination is where moving in a direction leads.
+type Destination struct {
+	Area   string
+	Room   string
+	CubeID string
+	// Door is set when the move leaves the room.
+	Door bool
+}
+
+// FindExits returns where players can move from the cube pos, by direction.
+// A step onto a door leads where the door does. Exits of the cube itself that
+// have a direction, such as stairs going up or down, come before the cubes
+// around it.
+func FindExits(s [][]Cube, area, room, pos string) map[Direction]Destination {
 	// TODO: Randomize door exit.
-	// TODO: ADD  NE , NW , SE , SW
-	// TODO: Make the 2d array a struct
-
-	ctype := "cube"
-	// First field denotes direction:
-	// [0] East, [1] West, [2] North, [3] South
-	// Second field holds the cube we will end up following the direction
-	// [][0] ToArea, [][1] ToCubeID, [][2] ToRoom
-	exitarr := [][]string{}
-
-	// area, id, room, cube type
-	east := []string{area, "0", room, ctype}
-	west := []string{area, "0", room, ctype}
-	north := []string{area, "0", room, ctype}
-	south := []string{area, "0", room, ctype}
-
-	exitarr = append(exitarr, east)
-	exitarr = append(exitarr, west)
-	exitarr = append(exitarr, north)
-	exitarr = append(exitarr, south)
-
-	east_id := 0
-	west_id := 0
-	north_id := 0
-	south_id := 0
+	exits := make(map[Direction]Destination)
 
-	for y := 0; y < len(s); y++ {
-		for x := 0; x < len(s[y]); x++ {
-			if s[x][y].ID == pos {
-				if x < len(s)-1 {
-					east_id, _ = strconv.Atoi(s[x+1][y].ID)
-				}
-				if x > 0 {
-					west_id, _ = strconv.Atoi(s[x-1][y].ID)
+	for x := 0; x < len(s); x++ {
+		for y := 0; y < len(s[x]); y++ {
+			if s[x][y].ID != pos {
+				continue
+			}
+
+			for _, exit := range s[x][y].Exits {
+				d, ok := ParseDirection(exit.Direction)
+				if !ok {
+					continue
 				}
-				if y > 0 {
-					north_id, _ = strconv.Atoi(s[x][y-1].ID)
+				to := Destination{Area: exit.ToArea, Room: exit.ToRoom, CubeID: exit.ToCubeID}
+				if to.Area == "" {
+					to.Area = area
 				}
-				if y < len(s)-1 {
-					south_id, _ = strconv.Atoi(s[x][y+1].ID)
+				if to.Room == "" {
+					to.Room = room
 				}
+				to.Door = to.Area != area || to.Room != room
+				exits[d] = to
+			}
 
-				if east_id > 0 {
-					if s[x+1][y].Type == "door" {
-						exitarr[0][0] = s[x+1][y].Exits[0].ToArea
-						exitarr[0][1] = s[x+1][y].Exits[0].ToCubeID
-						exitarr[0][2] = s[x+1][y].Exits[0].ToRoom
-						exitarr[0][3] = "door"
-					} else {
-						exitarr[0][1] = s[x+1][y].ID //EAST
-					}
+			for d, offset := range directionOffsets {
+				if _, ok := exits[d]; ok {
+					continue
 				}
-
-				if west_id > 0 {
-					if s[x-1][y].Type == "door" {
-						exitarr[1][0] = s[x-1][y].Exits[0].ToArea
-						exitarr[1][1] = s[x-1][y].Exits[0].ToCubeID
-						exitarr[1][2] = s[x-1][y].Exits[0].ToRoom
-						exitarr[1][3] = "door"
-					} else {
-						exitarr[1][1] = s[x-1][y].ID //WEST
-					}
+				nx, ny := x+offset[0], y+offset[1]
+				if nx < 0 || nx >= len(s) || ny < 0 || ny >= len(s[nx]) {
+					continue
 				}
-
-				if north_id > 0 {
-					if s[x][y-1].Type == "door" {
-						exitarr[2][0] = s[x][y-1].Exits[0].ToArea
-						exitarr[2][1] = s[x][y-1].Exits[0].ToCubeID
-						exitarr[2][2] = s[x][y-1].Exits[0].ToRoom
-						exitarr[2][3] = "door"
-					} else {
-						exitarr[2][1] = s[x][y-1].ID //NORTH
-					}
+				next := s[nx][ny]
+				if id, _ := strconv.Atoi(next.ID); id <= 0 {
+					continue
 				}
-
-				if south_id > 0 {
-					if s[x][y+1].Type == "door" {
-						exitarr[3][0] = s[x][y+1].Exits[0].ToArea
-						exitarr[3][1] = s[x][y+1].Exits[0].ToCubeID
-						exitarr[3][2] = s[x][y+1].Exits[0].ToRoom
-						exitarr[3][3] = "door"
-					} else {
-						exitarr[3][1] = s[x][y+1].ID //SOUTH
-					}
+				if next.Type == "door" && len(next.Exits) > 0 {
+					exits[d] = Destination{Area: next.Exits[0].ToArea, Room: next.Exits[0].ToRoom, CubeID: next.Exits[0].ToCubeID, Door: true}
+				} else {
+					exits[d] = Destination{Area: area, Room: room, CubeID: next.ID}
 				}
 			}
+			return exits
 		}
 	}
-
-	return exitarr
+	return exits
 }
 
 // Print Available Movement
-func PrintExits(exit_array [][]string) bytes.Buffer {
+func PrintExits(exits map[Direction]Destination) bytes.Buffer {
 	var buffer bytes.Buffer
 
 	buffer.WriteString("Movement: [ ")
-
-	if exit_array[0][1] != "0" {
-		buffer.WriteString("→ ")
+	for _, d := range Directions {
+		if _, ok := exits[d]; ok {
+			buffer.WriteString(directionSigns[d] + " ")
+		}
 	}
+	buffer.WriteString("]\n")
+	return buffer
+}
 
-	if exit_array[1][1] != "0" {
-		buffer.WriteString("← ")
-	}
+// Tile is what a cube shows on the map. How it is drawn is up to the client.
+type Tile int
+
+const (
+	TileEmpty  Tile = iota // nothing, outside of the room
+	TileWall               // not walkable
+	TileFloor              // walkable cube
+	TileDoor               // exit to another room
+	TilePlayer             // the player the map is drawn for
+	TileOther              // any other online player
+	TileStairs             // cube with exits up or down
+)
 
-	if exit_array[2][1] != "0" {
-		buffer.WriteString("↑ ")
+// Glyph returns the rune the tile is drawn with.
+func (t Tile) Glyph() rune {
+	switch t {
+	case TileWall:
+		return rune(182)
+	case TileFloor:
+		return rune(183)
+	case TileDoor:
+		return rune(398)
+	case TilePlayer:
+		return rune(198)
+	case TileOther:
+		return rune(165)
+	case TileStairs:
+		return rune(8801)
 	}
+	return ' '
+}
 
-	if exit_array[3][1] != "0" {
-		buffer.WriteString("↓ ")
+// tileAt returns the tile of the cube at x, y. online holds the positions of
+// the online players, true for the player the map is drawn for.
+func tileAt(s [][]Cube, online map[string]bool, x, y int) Tile {
+	current, ok := online[s[x][y].ID]
+	switch {
+	case s[x][y].Type == "door":
+		return TileDoor
+	case ok && current:
+		return TilePlayer
+	case ok && !current:
+		return TileOther
+	case s[x][y].ID == "":
+		if hasEmptyNeighbours(s, x, y) {
+			return TileEmpty
+		}
+		return TileWall
 	}
-
-	buffer.WriteString("]\n")
-	return buffer
+	for _, exit := range s[x][y].Exits {
+		if exit.Direction == "up" || exit.Direction == "down" {
+			return TileStairs
+		}
+	}
+	return TileFloor
 }
 
-func PlayerCentricMap(p *Player, online map[string]bool, s [][]Cube) bytes.Buffer {
-	var buffer bytes.Buffer
-	var buffer2 bytes.Buffer
+// PlayerCentricMap returns the tiles around the player, one row per line.
+// Rows without anything to show are left out.
+func PlayerCentricMap(p *Player, online map[string]bool, s [][]Cube) [][]Tile {
+	var tiles [][]Tile
 
 	r := 8
 	px := 0
@@ -187,6 +303,8 @@ func PlayerCentricMap(p *Player, online map[string]bool, s [][]Cube) bytes.Buffe
 	}
 
 	for y1 := 0; y1 < len(s); y1++ {
+		var row []Tile
+		empty := true
 		for x1 := 0; x1 < len(s[y1]); x1++ {
 
 			// Radius in Circle usage :
@@ -195,39 +313,19 @@ func PlayerCentricMap(p *Player, online map[string]bool, s [][]Cube) bytes.Buffe
 			// so,
 			// Calculating radius in Square shape.
 			if x1 >= px-r && x1 <= px+r && y1 >= py-r && y1 <= py+r {
-
-				current, ok := online[s[x1][y1].ID]
-				switch {
-				case s[x1][y1].Type == "door":
-					buffer.WriteString(string(ansi.Attribute(398)))
-				case ok && current:
-					buffer.WriteString(string(ansi.Attribute(198)))
-				case ok && !current:
-					buffer.WriteString(string(ansi.Attribute(165)))
-				case s[x1][y1].ID == "":
-					if hasEmptyNeighbours(s, x1, y1) {
-						buffer.WriteString("")
-					} else {
-						buffer.WriteString(string(ansi.Attribute(182)))
-					}
-				default:
-					buffer.WriteString(string(ansi.Attribute(183)))
+				tile := tileAt(s, online, x1, y1)
+				if tile != TileEmpty {
+					empty = false
 				}
+				row = append(row, tile)
 			}
 		}
-		buffer.WriteString("\n")
-	}
-
-	// Clear empty lines.
-	buf := bytes.NewBuffer(buffer.Bytes())
-	for i := 0; i < buffer.Len(); i++ {
-		line, _ := buf.ReadString('\n')
-		if len(line) > 1 {
-			buffer2.WriteString(line)
+		// Clear empty lines.
+		if !empty {
+			tiles = append(tiles, row)
 		}
 	}
-	return buffer2
-
+	return tiles
 }
 
 // Generate Map
@@ -236,23 +334,7 @@ func PrintMap(p *Player, online map[string]bool, s [][]Cube) bytes.Buffer {
 
 	for y := 0; y < len(s); y++ {
 		for x := 0; x < len(s); x++ {
-			current, ok := online[s[x][y].ID]
-			switch {
-			case s[x][y].Type == "door":
-				buffer.WriteString(string(ansi.Attribute(398)))
-			case ok && current:
-				buffer.WriteString(string(ansi.Attribute(198)))
-			case ok && !current:
-				buffer.WriteString(string(ansi.Attribute(165)))
-			case s[x][y].ID == "":
-				if hasEmptyNeighbours(s, x, y) {
-					buffer.WriteString("")
-				} else {
-					buffer.WriteString(string(ansi.Attribute(182)))
-				}
-			default:
-				buffer.WriteString(string(ansi.Attribute(183)))
-			}
+			buffer.WriteRune(tileAt(s, online, x, y).Glyph())
 		}
 		buffer.WriteString("\n")
 	}
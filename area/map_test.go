@@ -0,0 +1,67 @@
+package area
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindExits(t *testing.T) {
+	// Cubes are indexed by x, then y. Cube 2 is a door and cube 5 has
+	// stairs going up and an exit to the east over the missing cube.
+	grid := [][]Cube{
+		{{ID: "1"}, {ID: "4"}, {ID: "6"}},
+		{{ID: "2", Type: "door", Exits: []Exit{{ToArea: "City", ToRoom: "Market", ToCubeID: "9"}}}, {ID: "5", Exits: []Exit{
+			{Direction: "up", ToRoom: "Landing", ToCubeID: "1"},
+			{Direction: "east", ToArea: "Arena", ToRoom: "Cage", ToCubeID: "3"},
+		}}, {ID: "7"}},
+		{{ID: "3"}, {ID: ""}, {}},
+	}
+
+	tests := []struct {
+		name string
+		pos  string
+
+		expected map[Direction]Destination
+	}{
+		{
+			name: "corner",
+			pos:  "1",
+			expected: map[Direction]Destination{
+				East:      {Area: "City", Room: "Market", CubeID: "9", Door: true},
+				South:     {Area: "City", Room: "Inn", CubeID: "4"},
+				SouthEast: {Area: "City", Room: "Inn", CubeID: "5"},
+			},
+		},
+		{
+			name: "stairs and an exit over a cube",
+			pos:  "5",
+			expected: map[Direction]Destination{
+				North:     {Area: "City", Room: "Market", CubeID: "9", Door: true},
+				NorthWest: {Area: "City", Room: "Inn", CubeID: "1"},
+				NorthEast: {Area: "City", Room: "Inn", CubeID: "3"},
+				West:      {Area: "City", Room: "Inn", CubeID: "4"},
+				East:      {Area: "Arena", Room: "Cage", CubeID: "3", Door: true},
+				SouthWest: {Area: "City", Room: "Inn", CubeID: "6"},
+				South:     {Area: "City", Room: "Inn", CubeID: "7"},
+				Up:        {Area: "City", Room: "Landing", CubeID: "1", Door: true},
+			},
+		},
+		{
+			name:     "unknown cube",
+			pos:      "42",
+			expected: map[Direction]Destination{},
+		},
+	}
+
+	for _, test := range tests {
+		got := FindExits(grid, "City", "Inn", test.pos)
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, got)
+		}
+	}
+
+	exits := PrintExits(FindExits(grid, "City", "Inn", "5"))
+	if expected := "Movement: [ ↑ ↗ → ↓ ↙ ← ↖ up ]\n"; exits.String() != expected {
+		t.Errorf("expected the exits %q, got %q", expected, exits.String())
+	}
+}
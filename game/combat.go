@@ -0,0 +1,79 @@
+package game
+
+// Combatant is anyone who can take part in a fight.
+type Combatant interface {
+	Name() string
+	Stats() *PC
+	// HP returns the hit points left.
+	HP() int
+	// Hurt takes damage off the hit points left.
+	Hurt(damage int)
+}
+
+// EventKind tells what happened in a fight.
+type EventKind int
+
+const (
+	EventAttack EventKind = iota // A blow, that hit or missed
+	EventDefeat                  // The defender has no hit points left
+)
+
+/*
+CombatEvent is something that happened in a fight. Fights are told as a log of events, that can be shown to the
+players with a Narrator, kept to replay the fight or looked at to see who won.
+*/
+type CombatEvent struct {
+	Kind     EventKind
+	Round    int // The round of the fight, starting from one
+	Attacker string
+	Defender string
+	Attack
+	AC     int // The armor class the attack had to reach
+	HPLeft int // What the defender has left after the event
+}
+
+/*
+Resolve makes the attacker strike a blow to the defender and hurts the defender on a hit. It returns what happened:
+the attack, and the defeat of the defender when no hit points are left.
+*/
+func Resolve(dice Dice, round int, attacker, defender Combatant) []CombatEvent {
+	attack := Strike(dice, attacker.Stats(), defender.Stats())
+	if attack.Hit {
+		defender.Hurt(attack.Damage)
+	}
+	event := CombatEvent{
+		Kind:     EventAttack,
+		Round:    round,
+		Attacker: attacker.Name(),
+		Defender: defender.Name(),
+		Attack:   attack,
+		AC:       defender.Stats().AC,
+		HPLeft:   defender.HP(),
+	}
+	events := []CombatEvent{event}
+	if defender.HP() <= 0 {
+		event.Kind = EventDefeat
+		event.Attack = Attack{}
+		events = append(events, event)
+	}
+	return events
+}
+
+// maxRounds stops fights that nobody can win, as when neither side can reach the AC of the other.
+const maxRounds = 1000
+
+/*
+Fight makes two combatants fight until one of them is defeated, as the old simulator did. It returns the log of the
+fight, which ends with the defeat of the loser unless maxRounds went by.
+*/
+func Fight(dice Dice, first, second Combatant) []CombatEvent {
+	e := NewEncounter(dice)
+	e.Join(first, 0)
+	e.Join(second, 1)
+
+	var log []CombatEvent
+	for !e.Over() && e.Round < maxRounds {
+		log = append(log, e.NextRound()...)
+	}
+	return log
+}
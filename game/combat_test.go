@@ -0,0 +1,89 @@
+package game
+
+import (
+	"reflect"
+	"testing"
+)
+
+// dummy is a Combatant for tests.
+type dummy struct {
+	name   string
+	pc     PC
+	damage int
+}
+
+func (d *dummy) Name() string    { return d.name }
+func (d *dummy) Stats() *PC      { return &d.pc }
+func (d *dummy) HP() int         { return d.pc.HP - d.damage }
+func (d *dummy) Hurt(damage int) { d.damage += damage }
+
+func TestFight(t *testing.T) {
+	bob := &dummy{name: "Bob", pc: PC{STR: 10, DEX: 10, AC: 10, HP: 5, Weapondie: 6}}
+	rat := &dummy{name: "Rat", pc: PC{STR: 10, DEX: 10, AC: 12, HP: 3, Weapondie: 4}}
+
+	// The rat goes first on initiative, misses, and Bob kills it in two
+	// hits. The natural 20 of the second one is not confirmed as a
+	// critical hit.
+	got := Fight(scripted(8, 15, 5, 12, 2, 3, 20, 4, 4), bob, rat)
+	expected := []CombatEvent{
+		{Kind: EventAttack, Round: 1, Attacker: "Rat", Defender: "Bob", Attack: Attack{Roll: 5, Total: 5, DamageType: "bludgeoning"}, AC: 10, HPLeft: 5},
+		{Kind: EventAttack, Round: 1, Attacker: "Bob", Defender: "Rat", Attack: Attack{Roll: 12, Total: 12, Hit: true, DamageType: "bludgeoning", Damage: 2}, AC: 12, HPLeft: 1},
+		{Kind: EventAttack, Round: 2, Attacker: "Rat", Defender: "Bob", Attack: Attack{Roll: 3, Total: 3, DamageType: "bludgeoning"}, AC: 10, HPLeft: 5},
+		{Kind: EventAttack, Round: 2, Attacker: "Bob", Defender: "Rat", Attack: Attack{Roll: 20, Total: 20, Hit: true, Confirm: 4, DamageType: "bludgeoning", Damage: 4}, AC: 12, HPLeft: -3},
+		{Kind: EventDefeat, Round: 2, Attacker: "Bob", Defender: "Rat", AC: 12, HPLeft: -3},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected the fight\n%+v\ngot\n%+v", expected, got)
+	}
+}
+
+func TestNarrate(t *testing.T) {
+	n, err := NewTemplateNarrator(NarrationSet{
+		Hit: []string{"{{.Attacker}} hits {{.Defender}} for {{.Damage}}."},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		event CombatEvent
+
+		expected string
+	}{
+		{
+			name:     "custom template",
+			event:    CombatEvent{Attacker: "Bob", Defender: "Rat", Attack: Attack{Roll: 14, Hit: true, Damage: 3}},
+			expected: "Bob hits Rat for 3.",
+		},
+		{
+			name:     "default template",
+			event:    CombatEvent{Attacker: "Rat", Defender: "Bob", Attack: Attack{Roll: 2}},
+			expected: "Rat missed Bob.",
+		},
+		{
+			name:     "fumble",
+			event:    CombatEvent{Attacker: "Rat", Defender: "Bob", Attack: Attack{Roll: 1, Fumble: true}},
+			expected: "Rat stumbles and misses Bob by a mile.",
+		},
+		{
+			name:     "critical",
+			event:    CombatEvent{Round: 1, Attacker: "Bob", Defender: "Rat", Attack: Attack{Roll: 20, Hit: true, Critical: true, Weapon: "greataxe", DamageType: "slashing", Damage: 30}},
+			expected: "Bob finds a gap and the greataxe deals 30 points of slashing damage to Rat!",
+		},
+		{
+			name:     "defeat",
+			event:    CombatEvent{Kind: EventDefeat, Attacker: "Bob", Defender: "Rat"},
+			expected: "Rat is defeated!",
+		},
+	}
+	for _, test := range tests {
+		if got := n.Narrate(test.event); got != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, got)
+		}
+	}
+
+	if _, err := NewTemplateNarrator(NarrationSet{Miss: []string{"{{.Nobody}} missed."}}); err == nil {
+		t.Errorf("expected a template with an unknown field to be rejected")
+	}
+}
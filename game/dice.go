@@ -0,0 +1,129 @@
+package game
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+/*
+Dice rolls everything random in the game. A Dice created with the same seed rolls the same numbers, so a fight
+can be replayed and tests can tell the exact outcome.
+*/
+type Dice interface {
+	// Roll rolls one die with the given number of sides, from 1 to sides.
+	Roll(sides int) int
+	// D20 rolls a 20-side die.
+	D20() int
+	// Advantage rolls two 20-side dice and keeps the higher one.
+	Advantage() int
+	// DropLowest rolls n dice with the given number of sides and sums them, leaving out the lowest drop dice. drop is
+	// kept between 0 and n.
+	DropLowest(n, sides, drop int) int
+	// Eval rolls a dice expression, such as 2d6+1.
+	Eval(expr Expr) int
+}
+
+// NewDice returns dice seeded with seed. It must not be used by more than one goroutine.
+func NewDice(seed int64) Dice {
+	return &roller{rand.New(rand.NewSource(seed)).Intn}
+}
+
+// roller implements Dice with intn, which returns a number from 0 to n-1.
+type roller struct {
+	intn func(n int) int
+}
+
+func (r *roller) Roll(sides int) int {
+	if sides < 1 {
+		return 0
+	}
+	return r.intn(sides) + 1
+}
+
+func (r *roller) D20() int {
+	return r.Roll(20)
+}
+
+func (r *roller) Advantage() int {
+	first, second := r.D20(), r.D20()
+	if second > first {
+		return second
+	}
+	return first
+}
+
+func (r *roller) DropLowest(n, sides, drop int) int {
+	if n < 0 {
+		n = 0
+	}
+	if drop < 0 {
+		drop = 0
+	}
+	if drop > n {
+		drop = n
+	}
+	rolls := make([]int, n)
+	for i := range rolls {
+		rolls[i] = r.Roll(sides)
+	}
+	sort.Ints(rolls)
+	total := 0
+	for i := drop; i < n; i++ {
+		total += rolls[i]
+	}
+	return total
+}
+
+func (r *roller) Eval(expr Expr) int {
+	total := expr.Mod
+	for i := 0; i < expr.N; i++ {
+		total += r.Roll(expr.Sides)
+	}
+	return total
+}
+
+// Expr is a dice expression: N dice with the given number of sides, plus Mod.
+type Expr struct {
+	N, Sides, Mod int
+}
+
+func (e Expr) String() string {
+	switch {
+	case e.Mod > 0:
+		return fmt.Sprintf("%dd%d+%d", e.N, e.Sides, e.Mod)
+	case e.Mod < 0:
+		return fmt.Sprintf("%dd%d%d", e.N, e.Sides, e.Mod)
+	}
+	return fmt.Sprintf("%dd%d", e.N, e.Sides)
+}
+
+var exprPattern = regexp.MustCompile(`^\s*(\d*)[dD](\d+)\s*(?:([+-])\s*(\d+))?\s*$`)
+
+/*
+ParseDice reads a dice expression written as NdM+K, such as 1d8, d20, 2d6+1 or 3d4-2. The number of dice N defaults
+to one and the modifier K to zero.
+*/
+func ParseDice(s string) (Expr, error) {
+	m := exprPattern.FindStringSubmatch(s)
+	if m == nil {
+		return Expr{}, fmt.Errorf("invalid dice expression %q", s)
+	}
+	expr := Expr{N: 1}
+	if m[1] != "" {
+		expr.N, _ = strconv.Atoi(m[1])
+	}
+	expr.Sides, _ = strconv.Atoi(m[2])
+	if m[4] != "" {
+		expr.Mod, _ = strconv.Atoi(m[4])
+		if m[3] == "-" {
+			expr.Mod = -expr.Mod
+		}
+	}
+	if expr.N < 1 || expr.Sides < 1 {
+		return Expr{}, fmt.Errorf("invalid dice expression %q", s)
+	}
+	return expr, nil
+}
@@ -1,15 +1,11 @@
 package game
 
-import (
-	"sort"
-)
-
 /*
 Simple character attributes generator, rolling 4 six-sided dice, excluding the minor value of them and sum the rest three.
 If the result is lower than 8, automatically is raised to this number.
 */
 
-func create_character_dice() {
+func create_character_dice(dice Dice) {
 
 	player := &PC{
 		STR: 0,
@@ -20,8 +16,6 @@ func create_character_dice() {
 		CHA: 0,
 	}
 
-	dice := []int{0, 0, 0, 0}
-
 	var attribute *int
 
 	total := 0
@@ -43,14 +37,7 @@ func create_character_dice() {
 			attribute = &player.CHA
 		}
 
-		for i := 0; i < 4; i++ {
-			dice[i] = random(1, 6)
-		}
-
-		sort.Ints(dice)
-		//fmt.Println(dice)
-
-		total = dice[1] + dice[2] + dice[3] // Here we discard the first "die", as is the lowest number.
+		total = dice.DropLowest(4, 6, 1) // Here we discard the lowest "die".
 		if total < 8 {
 			for total < 8 {
 				total += 1
@@ -0,0 +1,180 @@
+package game
+
+import (
+	"reflect"
+	"testing"
+)
+
+// scripted returns dice that roll the given numbers, in order.
+func scripted(rolls ...int) Dice {
+	return &roller{intn: func(n int) int {
+		roll := rolls[0]
+		rolls = rolls[1:]
+		return roll - 1
+	}}
+}
+
+func TestParseDice(t *testing.T) {
+	tests := []struct {
+		expr string
+
+		expected Expr
+		wantErr  bool
+	}{
+		{expr: "1d8", expected: Expr{N: 1, Sides: 8}},
+		{expr: "d20", expected: Expr{N: 1, Sides: 20}},
+		{expr: "2d6+1", expected: Expr{N: 2, Sides: 6, Mod: 1}},
+		{expr: " 3D4 - 2 ", expected: Expr{N: 3, Sides: 4, Mod: -2}},
+		{expr: "0d6", wantErr: true},
+		{expr: "2d", wantErr: true},
+		{expr: "d6+", wantErr: true},
+		{expr: "six", wantErr: true},
+	}
+
+	for _, test := range tests {
+		got, err := ParseDice(test.expr)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%q: expected error %t, got %v", test.expr, test.wantErr, err)
+			continue
+		}
+		if got != test.expected {
+			t.Errorf("%q: expected %+v, got %+v", test.expr, test.expected, got)
+		}
+		if back, _ := ParseDice(got.String()); !test.wantErr && back != got {
+			t.Errorf("%q: %q does not parse back", test.expr, got.String())
+		}
+	}
+}
+
+func TestDice(t *testing.T) {
+	tests := []struct {
+		name  string
+		rolls []int
+		roll  func(d Dice) int
+
+		expected int
+	}{
+		{name: "d20", rolls: []int{17}, roll: Dice.D20, expected: 17},
+		{name: "advantage", rolls: []int{4, 15}, roll: Dice.Advantage, expected: 15},
+		{name: "advantage keeps the first", rolls: []int{12, 3}, roll: Dice.Advantage, expected: 12},
+		{
+			name:     "drop lowest",
+			rolls:    []int{3, 1, 6, 4},
+			roll:     func(d Dice) int { return d.DropLowest(4, 6, 1) },
+			expected: 13,
+		},
+		{
+			name:     "drop nothing",
+			rolls:    []int{3, 1},
+			roll:     func(d Dice) int { return d.DropLowest(2, 6, -1) },
+			expected: 4,
+		},
+		{
+			name:     "drop everything",
+			rolls:    []int{3, 1},
+			roll:     func(d Dice) int { return d.DropLowest(2, 6, 5) },
+			expected: 0,
+		},
+		{
+			name:     "expression",
+			rolls:    []int{2, 5},
+			roll:     func(d Dice) int { return d.Eval(Expr{N: 2, Sides: 6, Mod: -1}) },
+			expected: 6,
+		},
+	}
+
+	for _, test := range tests {
+		if got := test.roll(scripted(test.rolls...)); got != test.expected {
+			t.Errorf("%s: expected %d, got %d", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestSeededDice(t *testing.T) {
+	first, second := NewPC(NewDice(42)), NewPC(NewDice(42))
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("expected the same seed to make the same character, got %+v and %+v", first, second)
+	}
+}
+
+func TestRules(t *testing.T) {
+	fighter := &PC{STR: 14, BAB: 1, Weapon: "longsword", Weapondie: 8}
+	barbarian := &PC{STR: 16, BAB: 1, Weapon: "greataxe", Weapondie: 12}
+	orc := &PC{AC: 15}
+	dragon := &PC{AC: 30}
+
+	if name, ac := wearArmor(scripted(2), 16); name != "Chain Shirt" || ac != 17 {
+		t.Errorf("expected a Chain Shirt with AC 17, got %s with AC %d", name, ac)
+	}
+	if hp := calcHP(scripted(7, 3), "Fighter", 3); hp != 20 {
+		t.Errorf("expected a level 3 fighter to have 20 HP, got %d", hp)
+	}
+
+	tests := []struct {
+		name     string
+		attacker *PC
+		defender *PC
+		rolls    []int
+
+		expected Attack
+	}{
+		{
+			name:     "miss",
+			attacker: fighter,
+			rolls:    []int{11},
+			expected: Attack{Roll: 11, Total: 14, Weapon: "longsword", DamageType: "slashing"},
+		},
+		{
+			name:     "fumble",
+			attacker: fighter,
+			rolls:    []int{1},
+			expected: Attack{Roll: 1, Total: 4, Fumble: true, Weapon: "longsword", DamageType: "slashing"},
+		},
+		{
+			name:     "hit",
+			attacker: fighter,
+			rolls:    []int{12, 5},
+			expected: Attack{Roll: 12, Total: 15, Hit: true, Weapon: "longsword", DamageType: "slashing", Damage: 7},
+		},
+		{
+			name:     "natural 20 always hits",
+			attacker: &PC{STR: 8, Weapon: "fist"},
+			defender: dragon,
+			rolls:    []int{20, 8, 1},
+			expected: Attack{Roll: 20, Total: 19, Hit: true, Confirm: 8, Weapon: "fist", DamageType: "bludgeoning", Damage: 1},
+		},
+		{
+			name:     "threat not confirmed",
+			attacker: fighter,
+			rolls:    []int{19, 3, 4},
+			expected: Attack{Roll: 19, Total: 22, Hit: true, Confirm: 3, Weapon: "longsword", DamageType: "slashing", Damage: 6},
+		},
+		{
+			name:     "critical hit",
+			attacker: fighter,
+			rolls:    []int{19, 15, 2, 6},
+			expected: Attack{Roll: 19, Total: 22, Hit: true, Confirm: 15, Critical: true, Weapon: "longsword", DamageType: "slashing", Damage: 12},
+		},
+		{
+			name:     "greataxe critical hit",
+			attacker: barbarian,
+			rolls:    []int{20, 12, 10, 7, 12},
+			expected: Attack{Roll: 20, Total: 24, Hit: true, Confirm: 12, Critical: true, Weapon: "greataxe", DamageType: "slashing", Damage: 41},
+		},
+		{
+			name:     "greataxe threatens on 20 only",
+			attacker: barbarian,
+			rolls:    []int{19, 12},
+			expected: Attack{Roll: 19, Total: 23, Hit: true, Weapon: "greataxe", DamageType: "slashing", Damage: 16},
+		},
+	}
+	for _, test := range tests {
+		defender := orc
+		if test.defender != nil {
+			defender = test.defender
+		}
+		if got := Strike(scripted(test.rolls...), test.attacker, defender); got != test.expected {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package game
+
+import (
+	"sort"
+)
+
+// participant is a combatant taking part in an encounter.
+type participant struct {
+	Combatant
+	side       int
+	initiative int
+	target     Combatant // who it strikes, nil to pick the first foe in the initiative order
+}
+
+/*
+Encounter is a fight between any number of combatants on two or more sides. Combatants strike in the order of
+initiative, highest first. Draws go to the higher dexterity, and then to whoever joined first. Combatants can join
+and leave between rounds, and defeated ones leave on their own. The encounter is over once a single side is standing.
+*/
+type Encounter struct {
+	Round        int // The last round fought, zero before the first one
+	dice         Dice
+	participants []*participant // in the order of initiative
+}
+
+// NewEncounter starts an encounter where everything is rolled with the dice.
+func NewEncounter(dice Dice) *Encounter {
+	return &Encounter{dice: dice}
+}
+
+/*
+Join adds a combatant to a side of the encounter. It rolls initiative for the combatant, who strikes from the next
+round on, and returns it. Combatants already in the encounter stay where they are.
+*/
+func (e *Encounter) Join(c Combatant, side int) int {
+	if p := e.find(c); p != nil {
+		return p.initiative
+	}
+	p := &participant{Combatant: c, side: side, initiative: RollInitiative(e.dice, c.Stats())}
+	e.participants = append(e.participants, p)
+	sort.SliceStable(e.participants, func(i, j int) bool {
+		a, b := e.participants[i], e.participants[j]
+		if a.initiative != b.initiative {
+			return a.initiative > b.initiative
+		}
+		return a.Stats().DEX > b.Stats().DEX
+	})
+	return p.initiative
+}
+
+// Leave takes a combatant out of the encounter. Whoever was striking it picks another foe.
+func (e *Encounter) Leave(c Combatant) {
+	for i, p := range e.participants {
+		if p.Combatant == c {
+			e.participants = append(e.participants[:i], e.participants[i+1:]...)
+			break
+		}
+	}
+	for _, p := range e.participants {
+		if p.target == c {
+			p.target = nil
+		}
+	}
+}
+
+// In tells whether the combatant takes part in the encounter.
+func (e *Encounter) In(c Combatant) bool {
+	return e.find(c) != nil
+}
+
+// Side returns the side of a combatant in the encounter, or -1 for combatants not in it.
+func (e *Encounter) Side(c Combatant) int {
+	if p := e.find(c); p != nil {
+		return p.side
+	}
+	return -1
+}
+
+// Order returns the combatants of the encounter in the order they strike.
+func (e *Encounter) Order() []Combatant {
+	order := make([]Combatant, 0, len(e.participants))
+	for _, p := range e.participants {
+		order = append(order, p.Combatant)
+	}
+	return order
+}
+
+// Target makes a combatant strike a foe from now on. It returns false if either is not in the encounter or they are
+// on the same side.
+func (e *Encounter) Target(c, foe Combatant) bool {
+	p, f := e.find(c), e.find(foe)
+	if p == nil || f == nil || p.side == f.side {
+		return false
+	}
+	p.target = foe
+	return true
+}
+
+// TargetOf returns who a combatant strikes next, or nil if it has no foe left.
+func (e *Encounter) TargetOf(c Combatant) Combatant {
+	p := e.find(c)
+	if p == nil {
+		return nil
+	}
+	if p.target != nil {
+		return p.target
+	}
+	for _, other := range e.participants {
+		if other.side != p.side {
+			return other.Combatant
+		}
+	}
+	return nil
+}
+
+// Over tells whether no more than one side is left standing.
+func (e *Encounter) Over() bool {
+	_, ok := e.Winner()
+	return ok || len(e.participants) == 0
+}
+
+// Winner returns the side left standing once the encounter is over.
+func (e *Encounter) Winner() (int, bool) {
+	if len(e.participants) == 0 {
+		return -1, false
+	}
+	side := e.participants[0].side
+	for _, p := range e.participants[1:] {
+		if p.side != side {
+			return -1, false
+		}
+	}
+	return side, true
+}
+
+/*
+NextRound fights a round of the encounter: every combatant strikes its target in the order of initiative. Defeated
+combatants leave the encounter, and the round stops once it is over. It returns what happened.
+*/
+func (e *Encounter) NextRound() []CombatEvent {
+	if e.Over() {
+		return nil
+	}
+	e.Round++
+	var events []CombatEvent
+	for _, p := range append([]*participant(nil), e.participants...) {
+		if !e.In(p.Combatant) {
+			// Defeated earlier in the round.
+			continue
+		}
+		target := e.TargetOf(p.Combatant)
+		resolved := Resolve(e.dice, e.Round, p.Combatant, target)
+		events = append(events, resolved...)
+		if resolved[len(resolved)-1].Kind == EventDefeat {
+			e.Leave(target)
+		}
+		if e.Over() {
+			break
+		}
+	}
+	return events
+}
+
+func (e *Encounter) find(c Combatant) *participant {
+	for _, p := range e.participants {
+		if p.Combatant == c {
+			return p
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package game
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEncounterInitiative(t *testing.T) {
+	tests := []struct {
+		name  string
+		dex   []int
+		rolls []int
+
+		expected []string
+	}{
+		{name: "highest first", dex: []int{10, 10, 10}, rolls: []int{5, 17, 11}, expected: []string{"B", "C", "A"}},
+		{name: "dexterity breaks draws", dex: []int{10, 14, 10}, rolls: []int{12, 10, 15}, expected: []string{"C", "B", "A"}},
+		{name: "then whoever joined first", dex: []int{12, 12, 10}, rolls: []int{9, 9, 1}, expected: []string{"A", "B", "C"}},
+	}
+
+	for _, test := range tests {
+		e := NewEncounter(scripted(test.rolls...))
+		for i, name := range []string{"A", "B", "C"} {
+			e.Join(&dummy{name: name, pc: PC{DEX: test.dex[i]}}, i%2)
+		}
+		var got []string
+		for _, c := range e.Order() {
+			got = append(got, c.Name())
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: expected the order %v, got %v", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestEncounterRound(t *testing.T) {
+	bob := &dummy{name: "Bob", pc: PC{STR: 10, DEX: 10, AC: 10, HP: 5, Weapondie: 4}}
+	ann := &dummy{name: "Ann", pc: PC{STR: 10, DEX: 10, AC: 10, HP: 5, Weapondie: 4}}
+	ogre := &dummy{name: "Ogre", pc: PC{STR: 10, DEX: 10, AC: 10, HP: 2, Weapondie: 8}}
+
+	// Bob strikes first and fells the ogre before Ann gets to strike.
+	e := NewEncounter(scripted(10, 5, 8, 15, 2))
+	e.Join(bob, 0)
+	e.Join(ann, 0)
+	e.Join(ogre, 1)
+	got := e.NextRound()
+	expected := []CombatEvent{
+		{Kind: EventAttack, Round: 1, Attacker: "Bob", Defender: "Ogre", Attack: Attack{Roll: 15, Total: 15, Hit: true, DamageType: "bludgeoning", Damage: 2}, AC: 10},
+		{Kind: EventDefeat, Round: 1, Attacker: "Bob", Defender: "Ogre", AC: 10},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected the round\n%+v\ngot\n%+v", expected, got)
+	}
+	if e.In(ogre) {
+		t.Errorf("expected the ogre to leave once defeated")
+	}
+	if side, ok := e.Winner(); !ok || side != 0 {
+		t.Errorf("expected side 0 to win, got %d, %t", side, ok)
+	}
+	if events := e.NextRound(); events != nil {
+		t.Errorf("expected no more rounds once over, got %+v", events)
+	}
+}
+
+func TestEncounterTargets(t *testing.T) {
+	a, b, c := &dummy{name: "A"}, &dummy{name: "B"}, &dummy{name: "C"}
+	e := NewEncounter(scripted(3, 2, 1))
+	e.Join(a, 0)
+	e.Join(b, 1)
+	e.Join(c, 1)
+
+	if e.TargetOf(a) != b {
+		t.Errorf("expected A to strike the first foe, got %v", e.TargetOf(a))
+	}
+	if e.Target(b, c) {
+		t.Errorf("expected B not to be able to target someone on its side")
+	}
+	if !e.Target(a, c) || e.TargetOf(a) != c {
+		t.Errorf("expected A to strike C")
+	}
+
+	e.Leave(c)
+	if e.TargetOf(a) != b {
+		t.Errorf("expected A to turn to B once C left, got %v", e.TargetOf(a))
+	}
+	if e.Over() {
+		t.Errorf("expected the encounter to go on with a foe left")
+	}
+	e.Leave(b)
+	if !e.Over() {
+		t.Errorf("expected the encounter to be over with a single side left")
+	}
+	if e.Side(b) != -1 {
+		t.Errorf("expected B to have no side once gone")
+	}
+}
@@ -3,12 +3,6 @@ package game
 /*
 Combat simulator based on SRD v3.5 rules
 */
-import (
-	"fmt"
-	"math/rand"
-	"strconv"
-	"time"
-)
 
 // ------------Standard values-----------
 type PC struct { //Character's attributes.
@@ -32,43 +26,37 @@ type PC struct { //Character's attributes.
 
 /*
 Executing the function generateAttrib(), a random value from 8 to 18 is assigned for each of the attribute of the
-character and executing the function assignClass(), a random class from the three available is assigned.
+character and executing the function assignClass(), a random class from the three available is assigned. All the
+rolls are made with the given dice.
 */
-func NewPC() *PC {
+func NewPC(dice Dice) *PC {
 	player := &PC{
-		STR:   generateAttrib(),
-		DEX:   generateAttrib(),
-		CON:   generateAttrib(),
-		INT:   generateAttrib(),
-		WIS:   generateAttrib(),
-		CHA:   generateAttrib(),
+		STR:   generateAttrib(dice),
+		DEX:   generateAttrib(dice),
+		CON:   generateAttrib(dice),
+		INT:   generateAttrib(dice),
+		WIS:   generateAttrib(dice),
+		CHA:   generateAttrib(dice),
 		Level: 1,
-		Class: assignClass(),
+		Class: assignClass(dice),
 	}
 	/*
 		Weapon and armor are assigned randomly to the characters, but Hit Points and BAB are based on algorithms
 		according to the appropriate class
 	*/
-	player.Armor, player.AC = wearArmor(player.DEX)
-	player.HP = calcHP(player.Class, player.Level)
+	player.Armor, player.AC = wearArmor(dice, player.DEX)
+	player.HP = calcHP(dice, player.Class, player.Level)
 	player.BAB = calcBAB(player.Class, player.Level)
-	player.Weapon, player.Weapondie = weildWeapon()
-	player.Initiative = random(1, 20) + attrModifier(player.DEX)
+	player.Weapon, player.Weapondie = weildWeapon(dice)
+	player.Initiative = RollInitiative(dice, player)
 
 	return player
 }
 
 //------------Functions----------------
-// Retused function that trully picks number from lowest to maximum
-func random(min, max int) int {
-	max = max + 1
-	rand.Seed(time.Now().UTC().UnixNano()) // A sleep is vital for these calculations
-	return rand.Intn(max-min) + min
-}
-
 // General attribute creation function
-func generateAttrib() int {
-	return random(8, 18)
+func generateAttrib(dice Dice) int {
+	return dice.Roll(11) + 7
 }
 
 /*
@@ -82,8 +70,8 @@ func attrModifier(attribute int) int {
 /*
 This function picks an armor randomly. Then, it will calculate the total AC based on the armor's traits.
 */
-func wearArmor(dexterity int) (string, int) {
-	lottery := random(1, 5)
+func wearArmor(dice Dice, dexterity int) (string, int) {
+	lottery := dice.Roll(5)
 	var armorname string
 	var armorBonus, dexBonus int
 	dexBonus = attrModifier(dexterity)
@@ -126,8 +114,8 @@ func wearArmor(dexterity int) (string, int) {
 This method provides a weapon to the character. The variable weapon is the name of the weapon and the variable weapondie
 is the die that the weapon uses to calculate damage.
 */
-func weildWeapon() (string, int) {
-	lottery := random(1, 5)
+func weildWeapon(dice Dice) (string, int) {
+	lottery := dice.Roll(5)
 	var weapon string
 	var weapondie int
 	switch lottery {
@@ -153,8 +141,8 @@ func weildWeapon() (string, int) {
 /*
 A function to assign a class randomly to the character. This is essential to calculate other factors, like HP etc.
 */
-func assignClass() string { //To start with, three classes.
-	lottery := random(1, 3)
+func assignClass(dice Dice) string { //To start with, three classes.
+	lottery := dice.Roll(3)
 	var class string
 	switch lottery {
 	case 1:
@@ -190,7 +178,7 @@ Function of Hit Points calculation. Depends of the class and the level of the ch
 class has a specific die, that rolls in every level up and adds the result to the sum of his maximum
 hit points. In the first level, a character starts with the maximum number that this die can score.
 */
-func calcHP(class string, level int) int {
+func calcHP(dice Dice, class string, level int) int {
 	var HP int
 	var HD int
 	switch class {
@@ -200,7 +188,7 @@ func calcHP(class string, level int) int {
 		level -= 1
 		if level != 0 {
 			for i := 0; i < level; i++ {
-				HP += random(1, HD)
+				HP += dice.Roll(HD)
 			}
 		}
 	case "Fighter":
@@ -209,7 +197,7 @@ func calcHP(class string, level int) int {
 		level = level - 1
 		if level != 0 {
 			for i := 0; i < level; i++ {
-				HP += random(1, HD)
+				HP += dice.Roll(HD)
 			}
 		}
 	case "Rogue":
@@ -218,7 +206,7 @@ func calcHP(class string, level int) int {
 		level = level - 1
 		if level != 0 {
 			for i := 0; i < level; i++ {
-				HP += random(1, HD)
+				HP += dice.Roll(HD)
 			}
 		}
 	}
@@ -226,112 +214,61 @@ func calcHP(class string, level int) int {
 }
 
 /*
-Battle function. First strikes the comb1 and then comb2. Initiative is determined in main function
-Refactor of "for comb1.HP > 0 || comb2.HP > 0 {" gives fuzzy results. Don't know why.
+Initiative decides who strikes first in a fight. Encounter breaks draws by dexterity, then by who joined first.
 */
-func fight(comb1, comb2 *PC) {
-	for comb1.HP > 0 && comb2.HP > 0 {
-		if (random(1, 20) + comb1.BAB + attrModifier(comb1.STR)) >= comb2.AC {
-			hit := random(1, comb1.Weapondie)
-			comb2.HP -= hit
-			descrip := random(1, 4)
-
-			strhit := strconv.Itoa(hit)
-
-			switch descrip {
-			case 1:
-				fmt.Println("Player 2 was hit for " + strhit + " points of damage")
-			case 2:
-				fmt.Println("Player 2 was too slow, punished for " + strhit + " points of damage")
-			case 3:
-				fmt.Println("The evasion was worthless for Player 2, he suffered " + strhit + " points of damage")
-			case 4:
-				fmt.Println("If he brought a shield, Player 2 would avoid " + strhit + " points of damage")
-			}
+func RollInitiative(dice Dice, pc *PC) int {
+	return dice.D20() + attrModifier(pc.DEX)
+}
 
-		} else {
-			fmt.Println("Player 1 missed")
-		}
-		if comb2.HP < 0 {
-			break
-		}
-		if (random(1, 20) + comb2.BAB + attrModifier(comb2.STR)) >= comb1.AC {
-			hit := random(1, comb2.Weapondie)
-			comb1.HP -= hit
-			descrip := random(1, 4)
+// Attack is the outcome of one blow in a fight.
+type Attack struct {
+	Roll       int    // What the 20-side die showed
+	Total      int    // The roll plus the attack bonus of the attacker
+	Hit        bool   // Whether the blow landed, on a total reaching the AC of the defender or a natural 20
+	Fumble     bool   // Whether the die showed a natural 1, which always misses
+	Confirm    int    // The roll to confirm a critical threat, zero when the roll did not threaten
+	Critical   bool   // Whether the hit was a confirmed critical hit
+	Weapon     string // What the attacker struck with
+	DamageType string // The damage type of the weapon
+	Damage     int    // Points of damage dealt
+}
 
-			strhit := strconv.Itoa(hit)
+/*
+The attacker strikes a blow to the defender as in the SRD v3.5: a 20-side die plus the BAB and the strength bonus has
+to reach the defender's AC. A natural 1 always misses and a natural 20 always hits. A hit whose roll is in the critical
+range of the weapon threatens a critical hit, confirmed by a second attack roll that reaches the AC. A hit deals the
+weapon damage plus the strength bonus, at least 1 point, and a critical hit rolls it as many times as the critical
+multiplier of the weapon. Keeping score of the hit points is up to the caller.
+*/
+func Strike(dice Dice, attacker, defender *PC) Attack {
+	weapon := WeaponOf(attacker)
+	bonus := attacker.BAB + attrModifier(attacker.STR)
 
-			switch descrip {
-			case 1:
-				fmt.Println("Player 1 was hit for " + strhit + " points of damage")
-			case 2:
-				fmt.Println("Bad news Player 1, you were hit for " + strhit + " points of damage")
-			case 3:
-				fmt.Println("Player 1 surelly didn't expect to suffer " + strhit + " points of damage")
-			case 4:
-				fmt.Println("Learn some parry next time Player 1, because you took " + strhit + " points of damage")
-			}
-		} else {
-			fmt.Println("Player 2 missed")
-		}
+	attack := Attack{Roll: dice.D20(), Weapon: weapon.Name, DamageType: weapon.DamageType}
+	attack.Total = attack.Roll + bonus
+	switch {
+	case attack.Roll == 1:
+		attack.Fumble = true
+	case attack.Roll == 20, attack.Total >= defender.AC:
+		attack.Hit = true
 	}
-	fmt.Println("--@@--@@--@@--@@--")
-
-	strhp1 := strconv.Itoa(comb1.HP)
-	strhp2 := strconv.Itoa(comb2.HP)
-	if comb1.HP > 0 {
-		fmt.Println("Player 1 won, he's at " + strhp1 + " Hit Points, leaving his opponent at " + strhp2)
-	} else {
-		fmt.Println("Player 2 won, he's at " + strhp2 + "Hit Points, leaving his opponent at " + strhp1)
+	if !attack.Hit {
+		return attack
 	}
-}
-
-//------Main code------
-
-func do_fight() {
-	rand.Seed(time.Now().Unix())
 
-	// Setting up player 1
-	player1 := NewPC()
-	// Setting up player 2
-	player2 := NewPC()
-
-	// final output
-	fmt.Println("-----@@@@@@----@@@@@@@-----\nMy, what a characters you have here?\n-----@@@@@@----@@@@@@@-----")
-	fmt.Println("Player 1, which is a ", player1.Class, ", with ", player1.HP, "HP",
-		"his strength is", player1.STR,
-		"his dexterity is", player1.DEX,
-		"his constitution is", player1.CON,
-		"his intelligence is ", player1.INT,
-		"his wisdom is ", player1.WIS,
-		"and his charisma is", player1.CHA,
-		"He's wearing a ", player1.Armor, "providing him AC=", player1.AC, "and carries a ", player1.Weapon)
-	fmt.Println("He rolled initiative", player1.Initiative)
-	fmt.Println("-----------------------------------------------------------------------------------------------------")
-	fmt.Println("Player 2, which is a ", player2.Class, ", with ", player2.HP, "HP",
-		"his strength is", player2.STR,
-		"his dexterity is", player2.DEX,
-		"his constitution is", player2.CON,
-		"his intelligence is ", player2.INT,
-		"his wisdom is ", player2.WIS,
-		"and his charisma is", player2.CHA,
-		"He's wearing a ", player2.Armor, "providing him AC=", player2.AC, "and carries a ", player2.Weapon)
-	fmt.Println("He rolled initiative", player2.Initiative)
-	fmt.Println("----------------------\nLET THE FIGHT BEGIN!\n----------------------")
-
-	// Initiative calculation, in case of a draw initiatives are rerolled, else they are assigned in accordance with the function fight()
-	for player1.Initiative == player2.Initiative {
-		player1.Initiative = random(1, 20) + attrModifier(player1.DEX)
-		player2.Initiative = random(1, 20) + attrModifier(player2.DEX)
+	times := 1
+	if attack.Roll >= weapon.CritRange {
+		attack.Confirm = dice.D20()
+		if attack.Confirm == 20 || attack.Confirm != 1 && attack.Confirm+bonus >= defender.AC {
+			attack.Critical = true
+			times = weapon.CritMult
+		}
 	}
-
-	switch {
-	case player1.Initiative > player2.Initiative:
-		fight(player1, player2)
-	case player1.Initiative < player2.Initiative:
-		fight(player2, player1)
-	default:
-		fmt.Println("Problem!")
+	for i := 0; i < times; i++ {
+		attack.Damage += dice.Eval(weapon.Damage) + damageBonus(attacker, weapon)
+	}
+	if attack.Damage < 1 {
+		attack.Damage = 1
 	}
+	return attack
 }
@@ -0,0 +1,116 @@
+package game
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// Narrator tells the players what a combat event looks like.
+type Narrator interface {
+	Narrate(event CombatEvent) string
+}
+
+/*
+NarrationSet holds the templates a TemplateNarrator picks from, by outcome. Templates are text/template strings that
+are given the CombatEvent, such as "{{.Defender}} was hit for {{.Damage}} points of damage".
+*/
+type NarrationSet struct {
+	Fumble   []string `toml:"fumble"`
+	Miss     []string `toml:"miss"`
+	Hit      []string `toml:"hit"`
+	Critical []string `toml:"critical"`
+	Defeat   []string `toml:"defeat"`
+}
+
+// DefaultNarration is the flavor text of the old combat simulator.
+var DefaultNarration = NarrationSet{
+	Fumble: []string{
+		"{{.Attacker}} stumbles and misses {{.Defender}} by a mile.",
+	},
+	Miss: []string{
+		"{{.Attacker}} missed {{.Defender}}.",
+	},
+	Hit: []string{
+		"{{.Defender}} was hit by {{.Attacker}} for {{.Damage}} points of damage.",
+		"{{.Defender}} was too slow, punished by {{.Attacker}} for {{.Damage}} points of damage.",
+		"The evasion was worthless for {{.Defender}}, who suffered {{.Damage}} points of damage.",
+		"With a shield, {{.Defender}} would have avoided {{.Damage}} points of damage.",
+		"{{.Defender}} surely didn't expect to suffer {{.Damage}} points of damage from {{.Attacker}}.",
+		"Learn some parry next time {{.Defender}}, {{.Attacker}} dealt {{.Damage}} points of damage.",
+	},
+	Critical: []string{
+		"A critical hit! {{.Attacker}} dealt {{.Damage}} points of damage to {{.Defender}}.",
+		"{{.Attacker}} finds a gap and the {{.Weapon}} deals {{.Damage}} points of {{.DamageType}} damage to {{.Defender}}!",
+	},
+	Defeat: []string{
+		"{{.Defender}} is defeated!",
+	},
+}
+
+// TemplateNarrator narrates combat events with a NarrationSet.
+type TemplateNarrator struct {
+	fumble, miss, hit, critical, defeat []*template.Template
+}
+
+/*
+NewTemplateNarrator parses the templates of the set. Outcomes without templates use the ones of DefaultNarration.
+*/
+func NewTemplateNarrator(set NarrationSet) (*TemplateNarrator, error) {
+	n := &TemplateNarrator{}
+	for _, outcome := range []struct {
+		name      string
+		templates []string
+		fallback  []string
+		parsed    *[]*template.Template
+	}{
+		{"fumble", set.Fumble, DefaultNarration.Fumble, &n.fumble},
+		{"miss", set.Miss, DefaultNarration.Miss, &n.miss},
+		{"hit", set.Hit, DefaultNarration.Hit, &n.hit},
+		{"critical", set.Critical, DefaultNarration.Critical, &n.critical},
+		{"defeat", set.Defeat, DefaultNarration.Defeat, &n.defeat},
+	} {
+		texts := outcome.templates
+		if len(texts) == 0 {
+			texts = outcome.fallback
+		}
+		for i, text := range texts {
+			t, err := template.New(fmt.Sprintf("%s %d", outcome.name, i+1)).Parse(text)
+			if err != nil {
+				return nil, err
+			}
+			// Try it out, so that a mistake shows when it is loaded
+			// rather than in the middle of a fight.
+			if err := t.Execute(&bytes.Buffer{}, CombatEvent{}); err != nil {
+				return nil, err
+			}
+			*outcome.parsed = append(*outcome.parsed, t)
+		}
+	}
+	return n, nil
+}
+
+/*
+Narrate picks one of the templates for the outcome of the event. The same event is always told the same way, so that a
+replayed fight reads the same.
+*/
+func (n *TemplateNarrator) Narrate(event CombatEvent) string {
+	templates := n.hit
+	switch {
+	case event.Kind == EventDefeat:
+		templates = n.defeat
+	case event.Fumble:
+		templates = n.fumble
+	case !event.Hit:
+		templates = n.miss
+	case event.Critical:
+		templates = n.critical
+	}
+	t := templates[(event.Round+event.Roll+event.Damage)%len(templates)]
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, event); err != nil {
+		return err.Error()
+	}
+	return buf.String()
+}
@@ -2,8 +2,6 @@ package game
 
 import (
 	"fmt"
-	"math/rand"
-	"time"
 )
 
 /*
@@ -25,9 +23,9 @@ to the 6 attributes.
 */
 // ------------Standard values-----------
 
-func create_character() {
+func create_character(dice Dice) {
 	tokens := []int{3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 6, 6, 6, 6, 0, 0} // Tokens and their values.
-	tokens[16] = random(1, 6)                                             // The first empty token, taken a value from 1 to 6.
+	tokens[16] = dice.Roll(6)                                             // The first empty token, taken a value from 1 to 6.
 	tokens[17] = 6 - tokens[16]                                           // The second token, get's what is left from the first.
 
 	player := &PC{
@@ -62,8 +60,8 @@ func create_character() {
 		}
 
 		for i := 0; i < 3; i++ { // This loop picks a random token from the slice and stores it's value
-			numb := rand.Intn(len(tokens))     // then it pushes it to the end of the slice. After that, it redefines the slice without
-			time.Sleep(100 * time.Millisecond) // the final token.
+			numb := dice.Roll(len(tokens)) - 1 // then it pushes it to the end of the slice. After that, it redefines the slice without
+			// the final token.
 			*attribute += tokens[numb]
 			tokens[numb] = tokens[len(tokens)-1]
 			tokens = tokens[:len(tokens)-1]
@@ -72,7 +70,7 @@ func create_character() {
 		sum += *attribute
 	}
 
-	fmt.Println("\nSum of attributes = ", sum, "\n")
+	fmt.Print("\nSum of attributes = ", sum, "\n\n")
 	for i := 0; i < 20; i++ {
 		fmt.Print("-")
 	}
@@ -0,0 +1,61 @@
+package game
+
+import (
+	"fmt"
+)
+
+// Weapon is what a character strikes with, as described in the SRD v3.5 weapon tables.
+type Weapon struct {
+	Name       string
+	Damage     Expr   // The damage dice of a hit
+	CritRange  int    // The lowest natural roll that threatens a critical hit, 20 for most weapons
+	CritMult   int    // How many times the damage is rolled on a critical hit
+	DamageType string // bludgeoning, piercing or slashing
+	TwoHanded  bool   // Two-handed weapons add one and a half times the strength bonus to damage
+}
+
+// Weapons are the weapons of the game, by name.
+var Weapons = map[string]Weapon{
+	"fist":        {Name: "fist", Damage: Expr{N: 1, Sides: 3}, CritRange: 20, CritMult: 2, DamageType: "bludgeoning"},
+	"bite":        {Name: "bite", Damage: Expr{N: 1, Sides: 4}, CritRange: 20, CritMult: 2, DamageType: "piercing"},
+	"dagger":      {Name: "dagger", Damage: Expr{N: 1, Sides: 4}, CritRange: 19, CritMult: 2, DamageType: "piercing"},
+	"short sword": {Name: "short sword", Damage: Expr{N: 1, Sides: 6}, CritRange: 19, CritMult: 2, DamageType: "piercing"},
+	"longsword":   {Name: "longsword", Damage: Expr{N: 1, Sides: 8}, CritRange: 19, CritMult: 2, DamageType: "slashing"},
+	"greataxe":    {Name: "greataxe", Damage: Expr{N: 1, Sides: 12}, CritRange: 20, CritMult: 3, DamageType: "slashing", TwoHanded: true},
+}
+
+/*
+WeaponOf returns the weapon the character wields. Weapons missing from Weapons deal the character's Weapondie of
+bludgeoning damage and threaten a critical hit on a natural 20.
+*/
+func WeaponOf(pc *PC) Weapon {
+	if weapon, ok := Weapons[pc.Weapon]; ok {
+		return weapon
+	}
+	sides := pc.Weapondie
+	if sides < 1 {
+		sides = 1
+	}
+	return Weapon{Name: pc.Weapon, Damage: Expr{N: 1, Sides: sides}, CritRange: 20, CritMult: 2, DamageType: "bludgeoning"}
+}
+
+// String describes the weapon the way the SRD tables do, such as "1d8 19-20/x2".
+func (w Weapon) String() string {
+	threat := "20"
+	if w.CritRange < 20 {
+		threat = fmt.Sprintf("%d-20", w.CritRange)
+	}
+	return fmt.Sprintf("%s %s/x%d", w.Damage, threat, w.CritMult)
+}
+
+/*
+damageBonus is what the strength of the character adds to the damage of the weapon. Two-handed weapons add one and
+a half times a bonus, but a penalty is never increased.
+*/
+func damageBonus(pc *PC, weapon Weapon) int {
+	bonus := attrModifier(pc.STR)
+	if weapon.TwoHanded && bonus > 0 {
+		bonus = bonus * 3 / 2
+	}
+	return bonus
+}
@@ -34,19 +34,58 @@ func customFormat() log.Format {
 	})
 }
 
+var handler = log.StreamHandler(os.Stdout, customFormat())
+
 func init() {
-	h := log.StreamHandler(os.Stdout, customFormat())
-	log.Root().SetHandler(h)
+	log.Root().SetHandler(handler)
 }
 
-var port = flag.Int("port", 3030, "Port to listen on incoming connections")
+// Flags override anything set in server.toml or the environment.
+var (
+	configPath    = flag.String("config", "", "Path to server.toml (default: $THYRA_STATIC/server.toml)")
+	host          = flag.String("host", "", "Address to listen on incoming connections")
+	port          = flag.Int("port", 0, "Port to listen on incoming connections")
+	dbPath        = flag.String("db", "", "Path to the database file")
+	maxPlayers    = flag.Int("max-players", 0, "Maximum number of players online at the same time")
+	startArea     = flag.String("start-area", "", "Area new players start in")
+	startRoom     = flag.String("start-room", "", "Room new players start in")
+	startPosition = flag.String("start-position", "", "Cube new players start on")
+	logLevel      = flag.String("log-level", "", "One of debug, info, warn, error, crit")
+	resetDB       = flag.Bool("reset-db", false, "Delete all stored players on startup. Host keys are kept")
+	importDir     = flag.String("import-players", "", "Import the TOML player files found in the given directory into the database and exit")
+)
 
 func main() {
 	flag.Parse()
-	s, err := server.NewServer(*port)
+	s, err := server.NewServer(*configPath, server.Config{
+		Host:          *host,
+		Port:          *port,
+		DBPath:        *dbPath,
+		MaxPlayers:    *maxPlayers,
+		StartArea:     *startArea,
+		StartRoom:     *startRoom,
+		StartPosition: *startPosition,
+		LogLevel:      *logLevel,
+		ResetDB:       *resetDB,
+	})
 	if err != nil {
 		log.Error(err.Error())
 		os.Exit(1)
 	}
+	// The level has already been validated by the server.
+	lvl, _ := log.LvlFromString(s.Config().LogLevel)
+	log.Root().SetHandler(log.LvlFilterHandler(lvl, handler))
+
+	if *importDir != "" {
+		n, err := s.ImportPlayers(*importDir)
+		s.Close()
+		if err != nil {
+			log.Error(err.Error())
+			os.Exit(1)
+		}
+		log.Info(fmt.Sprintf("Imported %d players from %s", n, *importDir))
+		return
+	}
+
 	s.StartServer()
 }
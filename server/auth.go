@@ -0,0 +1,370 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gothyra/thyra/area"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// Keys of ssh.Permissions.Extensions set during authentication.
+const (
+	extMethod       = "method"
+	extFingerprint  = "pubkey-fp"
+	extPasswordHash = "password-hash" // only set when registering a password
+)
+
+// Values of extMethod.
+const (
+	methodPublicKey = "publickey"
+	methodPassword  = "password"
+	methodGuest     = "guest"
+)
+
+const minPasswordLength = 6
+
+var errPasswordLogin = errors.New("password login is disabled")
+
+// playerName turns the SSH user into a player nickname.
+func playerName(sshUser string) string {
+	// protect against XTR (cross terminal renderering) attacks
+	name := filtername.ReplaceAllString(sshUser, "")
+	// trim name
+	maxlen := 100
+	if len(name) > maxlen {
+		name = string([]rune(name)[:maxlen])
+	}
+	return name
+}
+
+// sshConfig returns the SSH server configuration for a new connection, and
+// where the algorithm its RSA host key signs the key exchange with is kept.
+func (s *Server) sshConfig() (*ssh.ServerConfig, *kexAlgorithm) {
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: s.authPublicKey,
+	}
+	// Guests without a key get in through the password methods.
+	if s.config.AllowPassword || s.config.AllowAnonymous {
+		config.PasswordCallback = s.authPassword
+		config.KeyboardInteractiveCallback = s.authKeyboardInteractive
+	}
+	kex := &kexAlgorithm{}
+	for _, signer := range s.hostKeys.forConnection(kex) {
+		config.AddHostKey(signer)
+	}
+	return config, kex
+}
+
+// isAnonymous reports whether the given name logs in as a guest.
+func (s *Server) isAnonymous(name string) bool {
+	return s.config.AllowAnonymous && name == s.config.AnonymousUser
+}
+
+// isGuestName reports whether the name is reserved for guests.
+func (s *Server) isGuestName(name string) bool {
+	return strings.HasPrefix(strings.ToLower(name), strings.ToLower(s.config.AnonymousUser)+"-")
+}
+
+// loadForAuth returns the player the client wants to log in as, or nil if
+// there is no such player yet.
+func (s *Server) loadForAuth(conn ssh.ConnMetadata) (string, *area.Player, error) {
+	name := playerName(conn.User())
+	if s.isGuestName(name) {
+		return name, nil, fmt.Errorf("%s is reserved for guests", name)
+	}
+	if name == "" || !isValidUsername(name) {
+		// The connection is refused once the session starts.
+		return name, nil, nil
+	}
+
+	player, err := s.store.Load(name)
+	switch {
+	case err == ErrPlayerNotFound:
+		return name, nil, nil
+	case err != nil:
+		log.Error(fmt.Sprintf("Cannot load player %q: %v", name, err))
+		return name, nil, err
+	}
+	return name, player, nil
+}
+
+// hasCredentials reports whether a key or a password is registered for the player.
+func hasCredentials(player *area.Player) bool {
+	return len(player.KeyFingerprints) > 0 || player.PasswordHash != ""
+}
+
+func guestPermissions() *ssh.Permissions {
+	return &ssh.Permissions{
+		Extensions: map[string]string{extMethod: methodGuest},
+	}
+}
+
+// authPublicKey accepts a key if it is registered for the player the client
+// wants to log in as. Unknown players and players without any credentials
+// are accepted so that the key can be registered on their first login.
+func (s *Server) authPublicKey(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	if s.isAnonymous(playerName(conn.User())) {
+		return guestPermissions(), nil
+	}
+
+	fingerprint := ssh.FingerprintSHA256(key)
+	perms := &ssh.Permissions{
+		Extensions: map[string]string{
+			extMethod:      methodPublicKey,
+			extFingerprint: fingerprint,
+		},
+	}
+
+	name, player, err := s.loadForAuth(conn)
+	if err != nil {
+		return nil, err
+	}
+	if player == nil || !hasCredentials(player) || hasKey(player, fingerprint) {
+		return perms, nil
+	}
+
+	log.Warn(fmt.Sprintf("Rejected key %s for player %q from %s", fingerprint, name, conn.RemoteAddr()))
+	return nil, fmt.Errorf("public key not registered for %s", name)
+}
+
+// authPassword checks the password of an existing player. For unknown players
+// and players without any credentials, the password gets registered on their
+// first login.
+func (s *Server) authPassword(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	if s.isAnonymous(playerName(conn.User())) {
+		return guestPermissions(), nil
+	}
+	if !s.config.AllowPassword {
+		return nil, errPasswordLogin
+	}
+
+	name, player, err := s.loadForAuth(conn)
+	if err != nil {
+		return nil, err
+	}
+	if player == nil || !hasCredentials(player) {
+		return registerPassword(password)
+	}
+
+	if player.PasswordHash == "" {
+		return nil, fmt.Errorf("no password set for %s", name)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(player.PasswordHash), password); err != nil {
+		log.Warn(fmt.Sprintf("Wrong password for player %q from %s", name, conn.RemoteAddr()))
+		return nil, fmt.Errorf("wrong password for %s", name)
+	}
+	return &ssh.Permissions{
+		Extensions: map[string]string{extMethod: methodPassword},
+	}, nil
+}
+
+// authKeyboardInteractive asks for the password of an existing player, or
+// lets a new player choose one.
+func (s *Server) authKeyboardInteractive(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+	if s.isAnonymous(playerName(conn.User())) {
+		return guestPermissions(), nil
+	}
+	if !s.config.AllowPassword {
+		return nil, errPasswordLogin
+	}
+
+	name, player, err := s.loadForAuth(conn)
+	if err != nil {
+		return nil, err
+	}
+	if player != nil && hasCredentials(player) {
+		answers, err := client("", "", []string{"Password: "}, []bool{false})
+		if err != nil {
+			return nil, err
+		}
+		if len(answers) != 1 {
+			return nil, errors.New("expected a password")
+		}
+		return s.authPassword(conn, []byte(answers[0]))
+	}
+
+	instruction := fmt.Sprintf("Welcome to Thyra! Choose a password for %s.", name)
+	answers, err := client(name, instruction, []string{"Password: ", "Confirm password: "}, []bool{false, false})
+	if err != nil {
+		return nil, err
+	}
+	if len(answers) != 2 || answers[0] != answers[1] {
+		return nil, errors.New("passwords do not match")
+	}
+	return registerPassword([]byte(answers[0]))
+}
+
+// registerPassword hashes the password chosen by a new player.
+func registerPassword(password []byte) (*ssh.Permissions, error) {
+	if len(password) < minPasswordLength {
+		return nil, fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword(password, bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	return &ssh.Permissions{
+		Extensions: map[string]string{
+			extMethod:       methodPassword,
+			extPasswordHash: string(hash),
+		},
+	}, nil
+}
+
+// bindCredentials ties the credentials accepted during the handshake to the
+// player. Players without any credentials get them registered. It reports
+// whether the player was changed.
+func bindCredentials(player *area.Player, ext map[string]string) (bool, error) {
+	switch ext[extMethod] {
+	case methodPublicKey:
+		return bindKey(player, ext[extFingerprint])
+	case methodPassword:
+		hash := ext[extPasswordHash]
+		switch {
+		case hash != "" && !hasCredentials(player):
+			log.Info(fmt.Sprintf("Registering password for player %q", player.Nickname))
+			player.PasswordHash = hash
+			return true, nil
+		case hash != "":
+			// Someone registered the name while we were authenticating.
+			return false, fmt.Errorf("%s is already registered", player.Nickname)
+		case player.PasswordHash == "":
+			return false, fmt.Errorf("no password set for %s", player.Nickname)
+		}
+		return false, nil
+	}
+	return false, fmt.Errorf("unknown login method %q", ext[extMethod])
+}
+
+// bindKey checks the fingerprint of the key used to log in against the ones
+// registered for the player. A player without credentials gets the
+// fingerprint registered. It reports whether the player was changed.
+func bindKey(player *area.Player, fingerprint string) (bool, error) {
+	if !hasCredentials(player) {
+		log.Info(fmt.Sprintf("Registering key %s for player %q", fingerprint, player.Nickname))
+		player.KeyFingerprints = []string{fingerprint}
+		return true, nil
+	}
+	if !hasKey(player, fingerprint) {
+		return false, fmt.Errorf("public key not registered for %s", player.Nickname)
+	}
+	return false, nil
+}
+
+func hasKey(player *area.Player, fingerprint string) bool {
+	for _, fp := range player.KeyFingerprints {
+		if fp == fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+// keysCommand lists, adds or revokes the SSH keys allowed to log in as the
+// client's player. It returns the text to show to the client.
+func (s *Server) keysCommand(c *Client, args []string) string {
+	p := c.Player
+
+	if p.Guest {
+		return "Guests cannot register keys.\n"
+	}
+
+	usage := "Usage: keys | keys add <public key> | keys revoke <fingerprint>\n"
+	if len(args) == 0 {
+		var lines []string
+		for i, fp := range p.KeyFingerprints {
+			current := ""
+			if fp == c.hash {
+				current = " (this session)"
+			}
+			lines = append(lines, fmt.Sprintf("%d. %s%s", i+1, fp, current))
+		}
+		return "Registered keys:\n" + strings.Join(lines, "\n") + "\n"
+	}
+
+	switch args[0] {
+	case "add":
+		if len(args) < 2 {
+			return usage
+		}
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.Join(args[1:], " ")))
+		if err != nil {
+			return fmt.Sprintf("Invalid public key: %v\n", err)
+		}
+		fingerprint := ssh.FingerprintSHA256(key)
+		if hasKey(p, fingerprint) {
+			return fmt.Sprintf("Key %s is already registered.\n", fingerprint)
+		}
+		p.KeyFingerprints = append(p.KeyFingerprints, fingerprint)
+		if err := s.savePlayer(*p); err != nil {
+			p.KeyFingerprints = p.KeyFingerprints[:len(p.KeyFingerprints)-1]
+			log.Error(fmt.Sprintf("Cannot save player %q: %v", p.Nickname, err))
+			return "Could not save the key, please try again later.\n"
+		}
+		log.Info(fmt.Sprintf("Player %q added key %s", p.Nickname, fingerprint))
+		return fmt.Sprintf("Added key %s.\n", fingerprint)
+
+	case "revoke":
+		if len(args) != 2 {
+			return usage
+		}
+		if !hasKey(p, args[1]) {
+			return fmt.Sprintf("Key %s is not registered.\n", args[1])
+		}
+		// Without any credentials the next login would claim the player.
+		if len(p.KeyFingerprints) == 1 && p.PasswordHash == "" {
+			return "You cannot revoke your only key.\n"
+		}
+		previous := p.KeyFingerprints
+		var kept []string
+		for _, fp := range previous {
+			if fp != args[1] {
+				kept = append(kept, fp)
+			}
+		}
+		p.KeyFingerprints = kept
+		if err := s.savePlayer(*p); err != nil {
+			p.KeyFingerprints = previous
+			log.Error(fmt.Sprintf("Cannot save player %q: %v", p.Nickname, err))
+			return "Could not revoke the key, please try again later.\n"
+		}
+		log.Info(fmt.Sprintf("Player %q revoked key %s", p.Nickname, args[1]))
+		return fmt.Sprintf("Revoked key %s.\n", args[1])
+	}
+
+	return usage
+}
+
+// isAdmin reports whether the player may run admin commands.
+func (s *Server) isAdmin(player *area.Player) bool {
+	if player.Guest {
+		return false
+	}
+	for _, name := range s.config.Admins {
+		if name == player.Nickname {
+			return true
+		}
+	}
+	return false
+}
+
+// rotateKeysCommand replaces the generated host keys. Clients learn about the
+// new keys the next time they connect during the grace period.
+func (s *Server) rotateKeysCommand(c *Client) string {
+	if !s.isAdmin(c.Player) {
+		return "Only admins can rotate the host keys.\n"
+	}
+	activeFrom, err := s.hostKeys.rotate()
+	if err != nil {
+		log.Error(fmt.Sprintf("Cannot rotate host keys: %v", err))
+		return fmt.Sprintf("Cannot rotate the host keys: %v\n", err)
+	}
+	log.Info(fmt.Sprintf("Player %q rotated the host keys", c.Player.Nickname))
+	return fmt.Sprintf("New host keys are announced and take over at %s.\n", activeFrom.Format(time.RFC1123))
+}
@@ -0,0 +1,362 @@
+package server
+
+import (
+	"crypto/rand"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gothyra/thyra/area"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+// testConn is the part of an SSH connection seen by the auth callbacks.
+type testConn struct {
+	ssh.ConnMetadata
+	user string
+}
+
+func (c testConn) User() string         { return c.user }
+func (c testConn) RemoteAddr() net.Addr { return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)} }
+
+func newTestKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestAuthPublicKey(t *testing.T) {
+	store, dir := newTestStore(t)
+	defer os.RemoveAll(dir)
+	defer store.db.Close()
+	s := &Server{store: store}
+
+	mikeKey, otherKey := newTestKey(t), newTestKey(t)
+	players := []area.Player{
+		{Nickname: "Mike", KeyFingerprints: []string{ssh.FingerprintSHA256(mikeKey)}},
+		{Nickname: "Seran"},
+	}
+	if err := store.Save(players...); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+
+		user string
+		key  ssh.PublicKey
+
+		wantErr bool
+	}{
+		{name: "registered key", user: "Mike", key: mikeKey},
+		{name: "unregistered key", user: "Mike", key: otherKey, wantErr: true},
+		{name: "player without keys", user: "Seran", key: otherKey},
+		{name: "new player", user: "Newbie", key: otherKey},
+	}
+
+	for _, test := range tests {
+		perms, err := s.authPublicKey(testConn{user: test.user}, test.key)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected the key to be rejected", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if got := perms.Extensions[extFingerprint]; got != ssh.FingerprintSHA256(test.key) {
+			t.Errorf("%s: expected fingerprint %s, got %s", test.name, ssh.FingerprintSHA256(test.key), got)
+		}
+	}
+}
+
+// newPasswordTest returns a server whose store holds Mike, with the password
+// "secret1", Keyed, with a key but no password, and Seran, without any
+// credentials.
+func newPasswordTest(t *testing.T, store PlayerStore) *Server {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret1"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	players := []area.Player{
+		{Nickname: "Mike", PasswordHash: string(hash)},
+		{Nickname: "Keyed", KeyFingerprints: []string{ssh.FingerprintSHA256(newTestKey(t))}},
+		{Nickname: "Seran"},
+	}
+	if err := store.Save(players...); err != nil {
+		t.Fatal(err)
+	}
+	return &Server{config: DefaultConfig(), store: store}
+}
+
+// checkPasswordPerms checks the permissions given by a password method.
+func checkPasswordPerms(t *testing.T, name string, perms *ssh.Permissions, err error, wantErr bool, registers string) {
+	if wantErr {
+		if err == nil {
+			t.Errorf("%s: expected the login to be rejected", name)
+		}
+		return
+	}
+	if err != nil {
+		t.Errorf("%s: unexpected error: %v", name, err)
+		return
+	}
+	if got := perms.Extensions[extMethod]; got != methodPassword {
+		t.Errorf("%s: expected the password method, got %q", name, got)
+	}
+	hash := perms.Extensions[extPasswordHash]
+	switch {
+	case registers == "" && hash != "":
+		t.Errorf("%s: expected no password to be registered", name)
+	case registers != "" && bcrypt.CompareHashAndPassword([]byte(hash), []byte(registers)) != nil:
+		t.Errorf("%s: expected %q to be registered", name, registers)
+	}
+}
+
+func TestAuthPassword(t *testing.T) {
+	store, dir := newTestStore(t)
+	defer os.RemoveAll(dir)
+	defer store.db.Close()
+	s := newPasswordTest(t, store)
+
+	tests := []struct {
+		name     string
+		disabled bool
+
+		user     string
+		password string
+
+		wantErr bool
+		// registers is the password registered on the first login.
+		registers string
+	}{
+		{name: "right password", user: "Mike", password: "secret1"},
+		{name: "wrong password", user: "Mike", password: "secret2", wantErr: true},
+		{name: "player with a key only", user: "Keyed", password: "secret1", wantErr: true},
+		{name: "player without credentials", user: "Seran", password: "hunter22", registers: "hunter22"},
+		{name: "new player", user: "Newbie", password: "hunter22", registers: "hunter22"},
+		{name: "password too short", user: "Newbie", password: "hunt", wantErr: true},
+		{name: "password login disabled", disabled: true, user: "Mike", password: "secret1", wantErr: true},
+	}
+
+	for _, test := range tests {
+		s.config.AllowPassword = !test.disabled
+		perms, err := s.authPassword(testConn{user: test.user}, []byte(test.password))
+		checkPasswordPerms(t, test.name, perms, err, test.wantErr, test.registers)
+	}
+}
+
+func TestAuthKeyboardInteractive(t *testing.T) {
+	store, dir := newTestStore(t)
+	defer os.RemoveAll(dir)
+	defer store.db.Close()
+	s := newPasswordTest(t, store)
+
+	tests := []struct {
+		name     string
+		disabled bool
+
+		user    string
+		answers []string
+
+		// questions is how many questions the client is asked.
+		questions int
+		wantErr   bool
+		registers string
+	}{
+		{name: "right password", user: "Mike", answers: []string{"secret1"}, questions: 1},
+		{name: "wrong password", user: "Mike", answers: []string{"secret2"}, questions: 1, wantErr: true},
+		{name: "new player", user: "Newbie", answers: []string{"hunter22", "hunter22"}, questions: 2, registers: "hunter22"},
+		{name: "passwords do not match", user: "Newbie", answers: []string{"hunter22", "hunter23"}, questions: 2, wantErr: true},
+		{name: "password login disabled", disabled: true, user: "Mike", wantErr: true},
+	}
+
+	for _, test := range tests {
+		s.config.AllowPassword = !test.disabled
+		questions := 0
+		challenge := func(user, instruction string, asked []string, echos []bool) ([]string, error) {
+			questions = len(asked)
+			return test.answers, nil
+		}
+		perms, err := s.authKeyboardInteractive(testConn{user: test.user}, challenge)
+		checkPasswordPerms(t, test.name, perms, err, test.wantErr, test.registers)
+		if questions != test.questions {
+			t.Errorf("%s: expected %d questions, got %d", test.name, test.questions, questions)
+		}
+	}
+}
+
+func TestBindCredentials(t *testing.T) {
+	tests := []struct {
+		name   string
+		player area.Player
+		ext    map[string]string
+
+		changed  bool
+		wantErr  bool
+		password string // the password hash of the player afterwards
+	}{
+		{
+			name:     "registers a password",
+			player:   area.Player{Nickname: "Seran"},
+			ext:      map[string]string{extMethod: methodPassword, extPasswordHash: "hash"},
+			changed:  true,
+			password: "hash",
+		},
+		{
+			name:     "registered meanwhile",
+			player:   area.Player{Nickname: "Seran", PasswordHash: "other"},
+			ext:      map[string]string{extMethod: methodPassword, extPasswordHash: "hash"},
+			wantErr:  true,
+			password: "other",
+		},
+		{
+			name:     "password login",
+			player:   area.Player{Nickname: "Mike", PasswordHash: "hash"},
+			ext:      map[string]string{extMethod: methodPassword},
+			password: "hash",
+		},
+		{
+			name:    "password login without a password",
+			player:  area.Player{Nickname: "Keyed", KeyFingerprints: []string{"SHA256:key"}},
+			ext:     map[string]string{extMethod: methodPassword},
+			wantErr: true,
+		},
+		{
+			name:     "unknown method",
+			player:   area.Player{Nickname: "Mike", PasswordHash: "hash"},
+			ext:      map[string]string{extMethod: "magic"},
+			wantErr:  true,
+			password: "hash",
+		},
+	}
+
+	for _, test := range tests {
+		player := test.player
+		changed, err := bindCredentials(&player, test.ext)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: expected error %t, got %v", test.name, test.wantErr, err)
+		}
+		if changed != test.changed {
+			t.Errorf("%s: expected changed %t, got %t", test.name, test.changed, changed)
+		}
+		if player.PasswordHash != test.password {
+			t.Errorf("%s: expected the password hash %q, got %q", test.name, test.password, player.PasswordHash)
+		}
+	}
+}
+
+func TestKeysCommand(t *testing.T) {
+	store, dir := newTestStore(t)
+	defer os.RemoveAll(dir)
+	defer store.db.Close()
+	s := &Server{store: failingStore{PlayerStore: store, broken: map[string]bool{"Broken": true}}}
+
+	key, newKey := newTestKey(t), newTestKey(t)
+	fp, newFP := ssh.FingerprintSHA256(key), ssh.FingerprintSHA256(newKey)
+	authorized := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(newKey)))
+
+	tests := []struct {
+		name   string
+		player area.Player
+		args   []string
+
+		reply string
+		keys  []string // the keys of the player afterwards
+		saved bool     // the keys were saved
+	}{
+		{
+			name:   "list",
+			player: area.Player{Nickname: "Mike", KeyFingerprints: []string{fp}},
+			reply:  "Registered keys:\n1. " + fp + " (this session)\n",
+			keys:   []string{fp},
+		},
+		{
+			name:   "add",
+			player: area.Player{Nickname: "Mike", KeyFingerprints: []string{fp}},
+			args:   append([]string{"add"}, strings.Fields(authorized)...),
+			reply:  "Added key " + newFP + ".\n",
+			keys:   []string{fp, newFP},
+			saved:  true,
+		},
+		{
+			name:   "add a registered key",
+			player: area.Player{Nickname: "Mike", KeyFingerprints: []string{fp, newFP}},
+			args:   append([]string{"add"}, strings.Fields(authorized)...),
+			reply:  "Key " + newFP + " is already registered.\n",
+			keys:   []string{fp, newFP},
+		},
+		{
+			name:   "add fails to save",
+			player: area.Player{Nickname: "Broken", KeyFingerprints: []string{fp}},
+			args:   append([]string{"add"}, strings.Fields(authorized)...),
+			reply:  "Could not save the key, please try again later.\n",
+			keys:   []string{fp},
+		},
+		{
+			name:   "revoke",
+			player: area.Player{Nickname: "Mike", KeyFingerprints: []string{fp, newFP}},
+			args:   []string{"revoke", newFP},
+			reply:  "Revoked key " + newFP + ".\n",
+			keys:   []string{fp},
+			saved:  true,
+		},
+		{
+			name:   "revoke the only key",
+			player: area.Player{Nickname: "Mike", KeyFingerprints: []string{fp}},
+			args:   []string{"revoke", fp},
+			reply:  "You cannot revoke your only key.\n",
+			keys:   []string{fp},
+		},
+		{
+			name:   "revoke the only key with a password",
+			player: area.Player{Nickname: "Mike", KeyFingerprints: []string{fp}, PasswordHash: "hash"},
+			args:   []string{"revoke", fp},
+			reply:  "Revoked key " + fp + ".\n",
+			saved:  true,
+		},
+		{
+			name:   "revoke fails to save",
+			player: area.Player{Nickname: "Broken", KeyFingerprints: []string{fp, newFP}},
+			args:   []string{"revoke", newFP},
+			reply:  "Could not revoke the key, please try again later.\n",
+			keys:   []string{fp, newFP},
+		},
+		{
+			name:   "guest",
+			player: area.Player{Nickname: "guest-1", Guest: true},
+			reply:  "Guests cannot register keys.\n",
+		},
+	}
+
+	for _, test := range tests {
+		if err := store.Save(area.Player{Nickname: "Mike"}); err != nil {
+			t.Fatal(err)
+		}
+		player := test.player
+		c := &Client{Player: &player, hash: fp}
+
+		if got := s.keysCommand(c, test.args); got != test.reply {
+			t.Errorf("%s: expected the reply %q, got %q", test.name, test.reply, got)
+		}
+		if !reflect.DeepEqual(player.KeyFingerprints, test.keys) {
+			t.Errorf("%s: expected the keys %v, got %v", test.name, test.keys, player.KeyFingerprints)
+		}
+		if saved, _ := store.Load("Mike"); test.saved && !reflect.DeepEqual(saved.KeyFingerprints, test.keys) {
+			t.Errorf("%s: expected the keys %v to be saved, got %v", test.name, test.keys, saved.KeyFingerprints)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gothyra/thyra/area"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// saveResult reports the players a batch failed to save.
+type saveResult struct {
+	failed []string
+	err    error
+}
+
+// autosave tracks the players that changed since they were last saved. It
+// belongs to the God goroutine, which is the only one changing players, and
+// hands copies of them to a saver goroutine so that God never waits on disk.
+type autosave struct {
+	store PlayerStore
+
+	dirty map[string]*area.Player
+	// inFlight holds the players of the batch being saved.
+	inFlight map[string]*area.Player
+
+	requests chan []area.Player
+	results  chan saveResult
+}
+
+func newAutosave(store PlayerStore) *autosave {
+	return &autosave{
+		store:    store,
+		dirty:    make(map[string]*area.Player),
+		inFlight: make(map[string]*area.Player),
+		requests: make(chan []area.Player, 1),
+		results:  make(chan saveResult, 1),
+	}
+}
+
+// markDirty schedules the player for the next save.
+func (a *autosave) markDirty(player *area.Player) {
+	if player.Guest {
+		return
+	}
+	a.dirty[player.Nickname] = player
+}
+
+// flush hands the given dirty players to the saver, or all of them if none
+// are given. It does nothing while a batch is still being saved; the players
+// stay dirty and go with the next one.
+func (a *autosave) flush(nicknames ...string) {
+	if len(a.inFlight) > 0 || len(a.dirty) == 0 {
+		return
+	}
+	if len(nicknames) == 0 {
+		for nick := range a.dirty {
+			nicknames = append(nicknames, nick)
+		}
+	}
+
+	var batch []area.Player
+	for _, nick := range nicknames {
+		player, ok := a.dirty[nick]
+		if !ok {
+			continue
+		}
+		delete(a.dirty, nick)
+		a.inFlight[nick] = player
+		batch = append(batch, *player)
+	}
+	if len(batch) > 0 {
+		a.requests <- batch
+	}
+}
+
+// done records the result of the last batch. Players that could not be saved
+// are marked dirty again unless they changed in the meantime.
+func (a *autosave) done(res saveResult) {
+	for _, nick := range res.failed {
+		if _, ok := a.dirty[nick]; !ok {
+			a.dirty[nick] = a.inFlight[nick]
+		}
+	}
+	a.inFlight = make(map[string]*area.Player)
+}
+
+// saveAll synchronously saves every dirty player, along with the last batch
+// in case it was not written. It is used once the saver has stopped.
+func (a *autosave) saveAll() error {
+	for nick, player := range a.inFlight {
+		if _, ok := a.dirty[nick]; !ok {
+			a.dirty[nick] = player
+		}
+	}
+	a.inFlight = make(map[string]*area.Player)
+
+	var batch []area.Player
+	for _, player := range a.dirty {
+		batch = append(batch, *player)
+	}
+	if res := saveBatch(a.store, batch); res.err != nil {
+		return res.err
+	}
+	a.dirty = make(map[string]*area.Player)
+	return nil
+}
+
+// saver writes the batches it receives until stopCh is closed.
+func (a *autosave) saver(stopCh <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for {
+		select {
+		case <-stopCh:
+			log.Info("saver is exiting.")
+			return
+		case batch := <-a.requests:
+			res := saveBatch(a.store, batch)
+			select {
+			case a.results <- res:
+			case <-stopCh:
+				log.Info("saver is exiting.")
+				return
+			}
+		}
+	}
+}
+
+// saveBatch saves all players in a single transaction. If that fails, the
+// players are saved one by one to find out which of them cannot be saved.
+func saveBatch(store PlayerStore, batch []area.Player) saveResult {
+	if len(batch) == 0 || store.Save(batch...) == nil {
+		return saveResult{}
+	}
+	res := saveResult{}
+	for _, player := range batch {
+		if err := store.Save(player); err != nil {
+			log.Error(fmt.Sprintf("Cannot save player %q: %v", player.Nickname, err))
+			res.failed = append(res.failed, player.Nickname)
+			res.err = err
+		}
+	}
+	return res
+}
+
+// godSaveDone handles the result of an autosave. Failures are shown to the
+// online admins.
+func (s *Server) godSaveDone(roomsMap map[string]map[string][][]area.Cube, res saveResult) {
+	s.autosave.done(res)
+	if res.err == nil {
+		return
+	}
+
+	msg := fmt.Sprintf("Autosave failed for %s: %v\n", strings.Join(res.failed, ", "), res.err)
+	for _, c := range s.OnlineClients() {
+		if c.ready && s.isAdmin(c.Player) {
+			godMessage([]Client{c}, msgSystem, msg)
+			s.godPrintRoom([]Client{c}, roomsMap)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/gothyra/thyra/area"
+)
+
+// failingStore fails to save the players listed in broken.
+type failingStore struct {
+	PlayerStore
+	broken map[string]bool
+}
+
+func (s failingStore) Save(players ...area.Player) error {
+	for _, p := range players {
+		if s.broken[p.Nickname] {
+			return errors.New("disk full")
+		}
+	}
+	return s.PlayerStore.Save(players...)
+}
+
+func TestAutosave(t *testing.T) {
+	store, dir := newTestStore(t)
+	defer os.RemoveAll(dir)
+	defer store.db.Close()
+
+	a := newAutosave(failingStore{PlayerStore: store, broken: map[string]bool{"Seran": true}})
+	mike := &area.Player{Nickname: "Mike", Position: "1"}
+	seran := &area.Player{Nickname: "Seran", Position: "1"}
+	guest := &area.Player{Nickname: "guest-1", Guest: true}
+
+	a.markDirty(mike)
+	a.markDirty(seran)
+	a.markDirty(guest)
+	if len(a.dirty) != 2 {
+		t.Fatalf("expected guests to be ignored, got %d dirty players", len(a.dirty))
+	}
+
+	a.flush()
+	// A second flush must wait for the first batch.
+	mike.Position = "2"
+	a.markDirty(mike)
+	a.flush()
+	if len(a.requests) != 1 {
+		t.Fatalf("expected a single batch in flight, got %d", len(a.requests))
+	}
+
+	res := saveBatch(a.store, <-a.requests)
+	if len(res.failed) != 1 || res.failed[0] != "Seran" {
+		t.Fatalf("expected Seran to fail, got %v", res.failed)
+	}
+	a.done(res)
+	if _, ok := a.dirty["Seran"]; !ok {
+		t.Error("expected Seran to be dirty again after a failed save")
+	}
+
+	got, err := store.Load("Mike")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Position != "1" {
+		t.Errorf("expected the first batch to save position 1, got %s", got.Position)
+	}
+
+	if err := a.saveAll(); err == nil {
+		t.Error("expected saveAll to report Seran's failure")
+	}
+	if got, _ := store.Load("Mike"); got == nil || got.Position != "2" {
+		t.Errorf("expected saveAll to save Mike's latest position, got %#v", got)
+	}
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gothyra/thyra/area"
+)
+
+// Chat rate limit: a player can send chatBurst messages at once, then one
+// more every chatInterval.
+const (
+	chatBurst    = 5
+	chatInterval = time.Second
+)
+
+// chatLimiter is a token bucket limiting how fast a client can chat.
+type chatLimiter struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow reports whether a message can be sent at the given time, and takes a
+// token if so.
+func (l *chatLimiter) allow(now time.Time) bool {
+	if l.last.IsZero() {
+		l.tokens = chatBurst
+	} else {
+		l.tokens += float64(now.Sub(l.last)) / float64(chatInterval)
+		if l.tokens > chatBurst {
+			l.tokens = chatBurst
+		}
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// isIgnoring reports whether the player ignores the given nickname.
+func isIgnoring(p *area.Player, nickname string) bool {
+	for _, ignored := range p.Ignored {
+		if ignored == nickname {
+			return true
+		}
+	}
+	return false
+}
+
+// matchNickname returns the nickname of nicknames that a player meant by
+// typing name: the one spelled the same, or else the only one that differs
+// in case. It returns "" if there is none, or several that differ in case.
+func matchNickname(name string, nicknames []string) string {
+	var matches []string
+	for _, nickname := range nicknames {
+		switch {
+		case nickname == name:
+			return nickname
+		case strings.EqualFold(nickname, name):
+			matches = append(matches, nickname)
+		}
+	}
+	if len(matches) != 1 {
+		return ""
+	}
+	return matches[0]
+}
+
+// listeners returns the clients that do not ignore the sender.
+func listeners(clients []Client, sender *Client) []Client {
+	var listening []Client
+	for _, c := range clients {
+		if !isIgnoring(c.Player, sender.Player.Nickname) {
+			listening = append(listening, c)
+		}
+	}
+	return listening
+}
+
+// onlineClient returns the client playing the given nickname, or nil.
+// Nicknames are case-sensitive: "Bob" and "bob" are different players.
+func (s *Server) onlineClient(nickname string) *Client {
+	s.RLock()
+	defer s.RUnlock()
+	return s.onlineClients[nickname]
+}
+
+// typedOnlineClient returns the online client a player meant by typing name,
+// as matchNickname finds it, or nil.
+func (s *Server) typedOnlineClient(name string) *Client {
+	s.RLock()
+	nicknames := make([]string, 0, len(s.onlineClients))
+	for nickname := range s.onlineClients {
+		nicknames = append(nicknames, nickname)
+	}
+	s.RUnlock()
+	return s.onlineClient(matchNickname(name, nicknames))
+}
+
+// onlineClientsInArea returns the online clients in the given area.
+func (s *Server) onlineClientsInArea(areaName string) []Client {
+	var clients []Client
+	for _, c := range s.OnlineClients() {
+		if c.Player.Area == areaName {
+			clients = append(clients, c)
+		}
+	}
+	return clients
+}
+
+// chatAllowed reports whether the client may chat now, telling it to slow
+// down if not.
+func (s *Server) chatAllowed(c *Client, roomsMap map[string]map[string][][]area.Cube) bool {
+	if c.chat.allow(time.Now()) {
+		return true
+	}
+	s.godReply(c, roomsMap, "You are talking too fast, wait a moment.")
+	return false
+}
+
+func (s *Server) sayCommand(c *Client, roomsMap map[string]map[string][][]area.Cube, args []string) {
+	if !s.chatAllowed(c, roomsMap) {
+		return
+	}
+	room := listeners(othersThan(s.OnlineClientsGetByRoom(c.Player.Area, c.Player.Room), c), c)
+	godMessage(room, msgSay, fmt.Sprintf("%s says: %s", c.Player.Nickname, args[0]))
+	godMessage([]Client{*c}, msgSay, fmt.Sprintf("You say: %s", args[0]))
+	s.godPrintRoom(append(room, *c), roomsMap)
+}
+
+func (s *Server) emoteCommand(c *Client, roomsMap map[string]map[string][][]area.Cube, args []string) {
+	if !s.chatAllowed(c, roomsMap) {
+		return
+	}
+	room := append(listeners(othersThan(s.OnlineClientsGetByRoom(c.Player.Area, c.Player.Room), c), c), *c)
+	godMessage(room, msgRoom, fmt.Sprintf("%s %s", c.Player.Nickname, args[0]))
+	s.godPrintRoom(room, roomsMap)
+}
+
+func (s *Server) shoutCommand(c *Client, roomsMap map[string]map[string][][]area.Cube, args []string) {
+	if !s.chatAllowed(c, roomsMap) {
+		return
+	}
+	listening := listeners(othersThan(s.onlineClientsInArea(c.Player.Area), c), c)
+	godMessage(listening, msgSay, fmt.Sprintf("%s shouts: %s", c.Player.Nickname, args[0]))
+	godMessage([]Client{*c}, msgSay, fmt.Sprintf("You shout: %s", args[0]))
+	s.godPrintAll(append(listening, *c), roomsMap)
+}
+
+func (s *Server) tellCommand(c *Client, roomsMap map[string]map[string][][]area.Cube, args []string) {
+	if s.chatAllowed(c, roomsMap) {
+		s.godTell(c, roomsMap, args[0], s.typedOnlineClient(args[0]), args[1])
+	}
+}
+
+func (s *Server) replyCommand(c *Client, roomsMap map[string]map[string][][]area.Cube, args []string) {
+	if s.chatAllowed(c, roomsMap) {
+		s.godTell(c, roomsMap, c.replyTo, s.onlineClient(c.replyTo), args[0])
+	}
+}
+
+// godTell sends a private message from c to target, the online client of
+// the player called to, or nil if they are not online.
+func (s *Server) godTell(c *Client, roomsMap map[string]map[string][][]area.Cube, to string, target *Client, text string) {
+	if to == "" {
+		s.godReply(c, roomsMap, "Nobody sent you a tell yet.")
+		return
+	}
+	switch {
+	case target == nil:
+		s.godReply(c, roomsMap, fmt.Sprintf("%s is not online.", to))
+		return
+	case target == c:
+		s.godReply(c, roomsMap, "You mumble to yourself.")
+		return
+	case isIgnoring(target.Player, c.Player.Nickname):
+		s.godReply(c, roomsMap, fmt.Sprintf("%s is ignoring you.", target.Player.Nickname))
+		return
+	}
+
+	target.replyTo = c.Player.Nickname
+	godMessage([]Client{*target}, msgTell, fmt.Sprintf("%s tells you: %s", c.Player.Nickname, text))
+	godMessage([]Client{*c}, msgTell, fmt.Sprintf("You tell %s: %s", target.Player.Nickname, text))
+	if target.linkdead {
+		godMessage([]Client{*c}, msgSystem, fmt.Sprintf("%s lost the connection and will read it later.", target.Player.Nickname))
+	}
+	s.godPrintRoom([]Client{*target}, roomsMap)
+	s.godPrintRoom([]Client{*c}, roomsMap)
+}
+
+// ignoreCommand lists the ignored players, or starts or stops ignoring one.
+// It returns the text to show to the client.
+func (s *Server) ignoreCommand(c *Client, args []string) string {
+	p := c.Player
+	if len(args) == 0 {
+		if len(p.Ignored) == 0 {
+			return "You are not ignoring anyone."
+		}
+		return "You are ignoring: " + strings.Join(p.Ignored, ", ") + "."
+	}
+
+	nick := args[0]
+	if ignored := matchNickname(nick, p.Ignored); ignored != "" {
+		for i := range p.Ignored {
+			if p.Ignored[i] == ignored {
+				p.Ignored = append(p.Ignored[:i], p.Ignored[i+1:]...)
+				break
+			}
+		}
+		s.autosave.markDirty(p)
+		return fmt.Sprintf("You stop ignoring %s.", ignored)
+	}
+	if other := s.typedOnlineClient(nick); other != nil {
+		nick = other.Player.Nickname
+	}
+	if nick == p.Nickname {
+		return "You cannot ignore yourself."
+	}
+	p.Ignored = append(p.Ignored, nick)
+	s.autosave.markDirty(p)
+	return fmt.Sprintf("You are now ignoring %s.", nick)
+}
+
+// godReply shows text to c alone.
+func (s *Server) godReply(c *Client, roomsMap map[string]map[string][][]area.Cube, text string) {
+	godMessage([]Client{*c}, msgSystem, text)
+	s.godPrintRoom([]Client{*c}, roomsMap)
+}
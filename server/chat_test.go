@@ -0,0 +1,172 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gothyra/thyra/area"
+)
+
+func TestChatLimiter(t *testing.T) {
+	start := time.Now()
+
+	tests := []struct {
+		name  string
+		after time.Duration
+
+		expected bool
+	}{
+		{name: "first", after: 0, expected: true},
+		{name: "burst 2", after: 0, expected: true},
+		{name: "burst 3", after: 0, expected: true},
+		{name: "burst 4", after: 0, expected: true},
+		{name: "burst 5", after: 0, expected: true},
+		{name: "over the burst", after: 0, expected: false},
+		{name: "too soon", after: chatInterval / 2, expected: false},
+		{name: "refilled", after: chatInterval, expected: true},
+		{name: "empty again", after: chatInterval, expected: false},
+		{name: "long pause", after: time.Hour, expected: true},
+	}
+
+	l := &chatLimiter{}
+	for _, test := range tests {
+		if got := l.allow(start.Add(test.after)); got != test.expected {
+			t.Errorf("%s: expected %t, got %t", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestMatchNickname(t *testing.T) {
+	tests := []struct {
+		name      string
+		typed     string
+		nicknames []string
+
+		expected string
+	}{
+		{name: "same spelling", typed: "Bob", nicknames: []string{"Mike", "Bob"}, expected: "Bob"},
+		{name: "other case", typed: "bob", nicknames: []string{"Mike", "Bob"}, expected: "Bob"},
+		{name: "same spelling among cases", typed: "bob", nicknames: []string{"BOB", "Bob", "bob"}, expected: "bob"},
+		{name: "several other cases", typed: "bOb", nicknames: []string{"Bob", "bob"}, expected: ""},
+		{name: "nobody", typed: "Ann", nicknames: []string{"Bob"}, expected: ""},
+	}
+
+	for _, test := range tests {
+		if got := matchNickname(test.typed, test.nicknames); got != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestChat(t *testing.T) {
+	type step struct{ who, line string }
+	tests := []struct {
+		name  string
+		steps []step
+		// linkdead makes Ann lose her connection first.
+		linkdead bool
+
+		// last is the last message of each player, by name.
+		last map[string]string
+	}{
+		{
+			name:  "say",
+			steps: []step{{"Bob", "say hi"}},
+			last:  map[string]string{"Bob": "You say: hi", "Mike": "Bob says: hi"},
+		},
+		{
+			name:  "say to someone ignoring you",
+			steps: []step{{"Mike", "ignore Bob"}, {"Bob", "say hi"}},
+			last:  map[string]string{"Bob": "You say: hi", "Mike": "You are now ignoring Bob."},
+		},
+		{
+			name:  "emote",
+			steps: []step{{"Bob", "emote waves"}},
+			last:  map[string]string{"Bob": "Bob waves", "Mike": "Bob waves"},
+		},
+		{
+			name:  "shout",
+			steps: []step{{"Bob", "shout hey"}},
+			last:  map[string]string{"Bob": "You shout: hey", "Mike": "Bob shouts: hey", "Ann": "Bob shouts: hey", "bob": "Bob shouts: hey"},
+		},
+		{
+			name:  "tell",
+			steps: []step{{"Bob", "tell ann psst"}},
+			last:  map[string]string{"Bob": "You tell Ann: psst", "Ann": "Bob tells you: psst"},
+		},
+		{
+			name:  "tell the exact nickname",
+			steps: []step{{"Bob", "tell bob psst"}},
+			last:  map[string]string{"Bob": "You tell bob: psst", "bob": "Bob tells you: psst"},
+		},
+		{
+			name:  "tell someone ignoring you",
+			steps: []step{{"Ann", "ignore Bob"}, {"Bob", "tell ann psst"}},
+			last:  map[string]string{"Bob": "Ann is ignoring you.", "Ann": "You are now ignoring Bob."},
+		},
+		{
+			name:     "tell someone linkdead",
+			steps:    []step{{"Bob", "tell ann psst"}},
+			linkdead: true,
+			last:     map[string]string{"Bob": "Ann lost the connection and will read it later.", "Ann": "Bob tells you: psst"},
+		},
+		{
+			name:  "tell someone offline",
+			steps: []step{{"Bob", "tell ghost psst"}},
+			last:  map[string]string{"Bob": "ghost is not online."},
+		},
+		{
+			name:  "reply to nobody",
+			steps: []step{{"Bob", "reply psst"}},
+			last:  map[string]string{"Bob": "Nobody sent you a tell yet."},
+		},
+		{
+			name:  "reply",
+			steps: []step{{"bob", "tell Bob hi"}, {"Bob", "reply hello"}},
+			last:  map[string]string{"Bob": "You tell bob: hello", "bob": "Bob tells you: hello"},
+		},
+		{
+			name:  "ignore list",
+			steps: []step{{"Bob", "ignore Mike"}, {"Bob", "ignore ann"}, {"Bob", "ignore"}},
+			last:  map[string]string{"Bob": "You are ignoring: Mike, Ann."},
+		},
+		{
+			name:  "stop ignoring",
+			steps: []step{{"Bob", "ignore Mike"}, {"Bob", "ignore mike"}},
+			last:  map[string]string{"Bob": "You stop ignoring Mike."},
+		},
+		{
+			name:  "ignore yourself",
+			steps: []step{{"Bob", "ignore Bob"}},
+			last:  map[string]string{"Bob": "You cannot ignore yourself."},
+		},
+	}
+
+	for _, test := range tests {
+		s := newSessionServer(sessionTakeover, time.Minute)
+		roomsMap := make(map[string]map[string][][]area.Cube)
+		players := []*area.Player{
+			{Nickname: "Bob", Area: "City", Room: "Inn", Position: "1"},
+			{Nickname: "Mike", Area: "City", Room: "Inn", Position: "2"},
+			{Nickname: "Ann", Area: "City", Room: "Square", Position: "1"},
+			{Nickname: "bob", Area: "City", Room: "Square", Position: "2"},
+			{Nickname: "Zed", Area: "Woods", Room: "Clearing", Position: "1"},
+		}
+		for i, p := range players {
+			c := newSessionClient(ID(i), p)
+			c.chat = &chatLimiter{}
+			s.onlineClients[p.Nickname] = c
+		}
+		s.onlineClients["Ann"].linkdead = test.linkdead
+
+		for _, step := range test.steps {
+			c := s.onlineClients[step.who]
+			s.godHandle(Event{Type: EventCommand, Client: c, Payload: CommandEvent{Line: step.line}}, roomsMap)
+		}
+		for _, p := range players {
+			if got := lastMessage(s.onlineClients[p.Nickname]); got != test.last[p.Nickname] {
+				t.Errorf("%s: expected the last message of %s to be %q, got %q", test.name, p.Nickname, test.last[p.Nickname], got)
+			}
+		}
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"math"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gothyra/thyra/area"
 
@@ -33,16 +35,28 @@ type Client struct {
 	hash                 string //hash of public key
 	SSHName, Name, cname string
 	w, h                 int // terminal size
-	ready                bool
+	profile              colorProfile
+	messages             *messageLog
+	chat                 *chatLimiter
+	replyTo              string // who sent the last tell
+	ready                bool   // the terminal is big enough, set by God
+	typing               int32  // the terminal is big enough to type, set by resizeWatch
+	promptW, promptH     int32  // the terminal size for the prompt bar, set by resizeWatch
+	linkdead             bool   // lost the connection, waiting for a reconnect
+	linkdeadSince        time.Time
 	resizes              chan resize
+	quit                 chan struct{} // closed when the client disconnects
+	quitOnce             *sync.Once
 	screen               *Screen
+	sshConn              ssh.Conn
+	out                  *outbox
 	conn                 *ansi.Ansi
 	promptBar            *PromptBar
 	Player               *area.Player
 }
 
 // NewPlayer returns an initialized Player.
-func NewClient(id ID, sshName, name, hash string, conn ssh.Channel, player *area.Player) *Client {
+func NewClient(id ID, sshName, name, hash string, sshConn ssh.Conn, out *outbox, player *area.Player) *Client {
 	if hash == "" {
 		hash = name //finally, hash fallsback to name
 	}
@@ -53,22 +67,39 @@ func NewClient(id ID, sshName, name, hash string, conn ssh.Channel, player *area
 		Name:      name,
 		ready:     false,
 		resizes:   make(chan resize),
-		conn:      ansi.Wrap(conn),
+		quit:      make(chan struct{}),
+		quitOnce:  &sync.Once{},
+		sshConn:   sshConn,
+		out:       out,
+		conn:      ansi.Wrap(out),
+		screen:    &Screen{},
 		promptBar: NewPromptBar(),
 		Player:    player,
 	}
+	// Closing the connection makes the client go linkdead.
+	out.overflow = func() { sshConn.Close() }
 	return p
 }
 
+// The smallest terminal the game can be played in.
+const minWidth, minHeight = 10, 10
+
+// fits tells whether a terminal is big enough to play.
+func fits(w, h int) bool {
+	return w >= minWidth && h >= minHeight
+}
+
 var resizeTmpl = string(ansi.Goto(2, 5)) +
 	string(ansi.Set(ansi.Blue)) +
 	"Please resize your terminal to %dx%d (+%dx+%d)" + string(ansi.Set(ansi.Default))
 
-func (c *Client) receiveActions(stopCh <-chan struct{}, wg *sync.WaitGroup) {
-	// defer wg.Done()
+func (c *Client) receiveActions(eventCh chan<- Event, stopCh <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
 
-	buff := make([]byte, 3)
+	buff := make([]byte, 1024)
 
+	// Ctrl-C leaves the game, a lost connection waits for a reconnect.
+	linkdead := true
 	for {
 		n, err := c.conn.Read(buff)
 
@@ -77,23 +108,68 @@ func (c *Client) receiveActions(stopCh <-chan struct{}, wg *sync.WaitGroup) {
 		}
 		b := buff[:n]
 		if b[0] == 3 {
+			linkdead = false
 			break
 		}
 
 		// Ignore until terminal size is more than requested.
-		if !c.ready {
+		if atomic.LoadInt32(&c.typing) == 0 {
 			continue
 		}
 
 		// Send byte array to Prompt bar channel
 		select {
 		case c.promptBar.promptChan <- b:
+		case <-c.quit:
+			log.Info("receiveActions is exiting.")
+			return
 		case <-stopCh:
 			log.Info("receiveActions is exiting.")
 			return
 		}
 	}
 
+	// Let God clean up after the client.
+	select {
+	case eventCh <- Event{Type: EventDisconnect, Client: c, Payload: DisconnectEvent{Linkdead: linkdead}}:
+	case <-c.quit:
+	case <-stopCh:
+	}
+	log.Info("receiveActions is exiting.")
+}
+
+// stop tells the goroutines of the client to exit and closes its connection
+// once the queued output is sent, or after a second for a stuck client. It is
+// safe to call more than once.
+func (c *Client) stop() {
+	c.quitOnce.Do(func() {
+		close(c.quit)
+		go func() {
+			select {
+			case <-c.out.done:
+			case <-time.After(time.Second):
+			}
+			c.sshConn.Close()
+		}()
+	})
+}
+
+// resize hands the new terminal size to resizeWatch.
+func (c *Client) resize(r resize) {
+	select {
+	case c.resizes <- r:
+	case <-c.quit:
+	}
+}
+
+// promptWidth is the width of the terminal for the prompt bar goroutine.
+func (c *Client) promptWidth() int {
+	return int(atomic.LoadInt32(&c.promptW))
+}
+
+// promptHeight is the height of the terminal for the prompt bar goroutine.
+func (c *Client) promptHeight() int {
+	return int(atomic.LoadInt32(&c.promptH))
 }
 
 func (c *Client) writeString(message string) {
@@ -107,29 +183,23 @@ func (c *Client) writeGoto(x, y int) {
 func (c *Client) prepareClient(eventCh chan Event, stopCh <-chan struct{}, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	// wg.Add(1)
-	go c.receiveActions(stopCh, wg)
+	wg.Add(1)
+	go c.out.writer(c.quit, stopCh, wg)
+
+	wg.Add(1)
+	go c.receiveActions(eventCh, stopCh, wg)
 
 	wg.Add(1)
 	go c.promptBar.promptBar(c, eventCh, stopCh, wg)
 
+	// God changes c.Player on takeover.
 	wg.Add(1)
-	go c.resizeWatch(eventCh, stopCh, wg)
+	go c.resizeWatch(c.Player.Nickname, eventCh, stopCh, wg)
 
 	log.Info("prepareClient complete.")
 }
 
-func (c *Client) resetScreen() {
-
-	for w := 0; w < c.w; w++ {
-		for h := 0; h < c.h-3; h++ {
-			c.screen.screenRunes[w][h] = ' '
-			c.screen.screenColors[w][h] = ID(255)
-		}
-	}
-}
-
-func (c *Client) resizeWatch(eventCh chan<- Event, stopCh <-chan struct{}, wg *sync.WaitGroup) {
+func (c *Client) resizeWatch(nickname string, eventCh chan<- Event, stopCh <-chan struct{}, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	for {
@@ -137,29 +207,35 @@ func (c *Client) resizeWatch(eventCh chan<- Event, stopCh <-chan struct{}, wg *s
 		case <-stopCh:
 			log.Info("resizeWatch is exiting.")
 			return
+		case <-c.quit:
+			log.Info("resizeWatch is exiting.")
+			return
 		case r := <-c.resizes:
-			c.w = int(r.width)
-			c.h = int(r.height)
-			log.Info(fmt.Sprintf("Player: %s, Width: %d,  Height: %d", c.Player.Nickname, c.w, c.h))
+			w, h := int(r.width), int(r.height)
+			log.Info(fmt.Sprintf("Player: %s, Width: %d,  Height: %d", nickname, w, h))
+			atomic.StoreInt32(&c.promptW, int32(w))
+			atomic.StoreInt32(&c.promptH, int32(h))
 
 			// fits?
-			if c.w >= 10 && c.h >= 10 {
+			if fits(w, h) {
 				c.conn.EraseScreen()
-				// send updates!
-				c.ready = true
-				c.screen = NewScreen(c.w, c.h)
-				select {
-				case eventCh <- Event{Client: c, EventType: ""}:
-				case <-stopCh:
-					return
-				}
+				c.out.markStale()
+				atomic.StoreInt32(&c.typing, 1)
 			} else {
 				// doesnt fit
 				c.conn.EraseScreen()
-				c.conn.Write([]byte(fmt.Sprintf(resizeTmpl, 10, 10,
-					int(math.Max(float64(10-c.w), 0)),
-					int(math.Max(float64(10-c.h), 0)))))
-				c.ready = false
+				c.conn.Write([]byte(fmt.Sprintf(resizeTmpl, minWidth, minHeight,
+					int(math.Max(float64(minWidth-w), 0)),
+					int(math.Max(float64(minHeight-h), 0)))))
+				atomic.StoreInt32(&c.typing, 0)
+			}
+			// God takes the new size and sends updates.
+			select {
+			case eventCh <- Event{Type: EventResize, Client: c, Payload: ResizeEvent{Width: w, Height: h}}:
+			case <-c.quit:
+				return
+			case <-stopCh:
+				return
 			}
 		}
 	}
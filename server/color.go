@@ -0,0 +1,223 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Color is the foreground or background color of a cell: the terminal
+// default, one of the 256 indexed colors or a 24-bit RGB color.
+type Color uint32
+
+const (
+	colorIndexed = 1 << 24
+	colorRGB     = 2 << 24
+)
+
+// ColorDefault is the terminal's own color.
+const ColorDefault Color = 0
+
+// The 16 basic colors.
+var (
+	Black         = Indexed(0)
+	Red           = Indexed(1)
+	Green         = Indexed(2)
+	Yellow        = Indexed(3)
+	Blue          = Indexed(4)
+	Magenta       = Indexed(5)
+	Cyan          = Indexed(6)
+	White         = Indexed(7)
+	BrightBlack   = Indexed(8)
+	BrightRed     = Indexed(9)
+	BrightGreen   = Indexed(10)
+	BrightYellow  = Indexed(11)
+	BrightBlue    = Indexed(12)
+	BrightMagenta = Indexed(13)
+	BrightCyan    = Indexed(14)
+	BrightWhite   = Indexed(15)
+)
+
+// Indexed returns one of the 256 indexed colors.
+func Indexed(n uint8) Color {
+	return Color(colorIndexed | uint32(n))
+}
+
+// RGB returns a 24-bit color.
+func RGB(r, g, b uint8) Color {
+	return Color(colorRGB | uint32(r)<<16 | uint32(g)<<8 | uint32(b))
+}
+
+// rgb returns the red, green and blue parts of any non-default color.
+func (c Color) rgb() (uint8, uint8, uint8) {
+	if c&colorRGB != 0 {
+		return uint8(c >> 16), uint8(c >> 8), uint8(c)
+	}
+	n := uint8(c)
+	switch {
+	case n < 16:
+		v := basicRGB[n]
+		return v[0], v[1], v[2]
+	case n < 232:
+		n -= 16
+		return cubeLevels[n/36], cubeLevels[n/6%6], cubeLevels[n%6]
+	}
+	v := 8 + 10*(n-232)
+	return v, v, v
+}
+
+// Style is a set of text attributes.
+type Style uint8
+
+const (
+	StyleBold Style = 1 << iota
+	StyleDim
+	StyleUnderline
+	StyleReverse
+)
+
+// Attr holds the colors and style of a cell. The zero Attr is the terminal's
+// default look.
+type Attr struct {
+	Fg, Bg Color
+	Style  Style
+}
+
+// colorProfile is how many colors a client's terminal can show.
+type colorProfile int
+
+const (
+	profileMono colorProfile = iota
+	profile16
+	profile256
+	profileTrueColor
+)
+
+// profileFromTerm guesses the color support of a terminal from its TERM and
+// COLORTERM variables. Unknown terminals get the 16 basic colors.
+func profileFromTerm(term, colorTerm string) colorProfile {
+	term = strings.ToLower(term)
+	colorTerm = strings.ToLower(colorTerm)
+	switch {
+	case term == "" || term == "dumb" || isVT(term):
+		return profileMono
+	case colorTerm == "truecolor" || colorTerm == "24bit",
+		strings.Contains(term, "truecolor"), strings.Contains(term, "24bit"), strings.HasSuffix(term, "-direct"):
+		return profileTrueColor
+	case strings.Contains(term, "256color"):
+		return profile256
+	}
+	return profile16
+}
+
+// isVT tells whether term is one of the DEC VT terminals, such as vt100 or
+// vt220, as opposed to VTE based ones such as vte-256color.
+func isVT(term string) bool {
+	return len(term) > 2 && strings.HasPrefix(term, "vt") && term[2] >= '0' && term[2] <= '9'
+}
+
+// visible returns the part of attr that the profile can show.
+func (p colorProfile) visible(attr Attr) Attr {
+	if p == profileMono {
+		attr.Fg, attr.Bg = ColorDefault, ColorDefault
+	}
+	return attr
+}
+
+// sgr returns the escape sequence that switches the terminal to attr. Colors
+// the profile cannot show are replaced by the closest one it can.
+func (p colorProfile) sgr(attr Attr) string {
+	params := []string{"0"}
+	if attr.Style&StyleBold != 0 {
+		params = append(params, "1")
+	}
+	if attr.Style&StyleDim != 0 {
+		params = append(params, "2")
+	}
+	if attr.Style&StyleUnderline != 0 {
+		params = append(params, "4")
+	}
+	if attr.Style&StyleReverse != 0 {
+		params = append(params, "7")
+	}
+	if p != profileMono {
+		if attr.Fg != ColorDefault {
+			params = append(params, p.colorParams(attr.Fg, false))
+		}
+		if attr.Bg != ColorDefault {
+			params = append(params, p.colorParams(attr.Bg, true))
+		}
+	}
+	return "\x1b[" + strings.Join(params, ";") + "m"
+}
+
+func (p colorProfile) colorParams(c Color, bg bool) string {
+	switch {
+	case p == profileTrueColor && c&colorRGB != 0:
+		r, g, b := c.rgb()
+		if bg {
+			return fmt.Sprintf("48;2;%d;%d;%d", r, g, b)
+		}
+		return fmt.Sprintf("38;2;%d;%d;%d", r, g, b)
+
+	case p >= profile256:
+		n := uint8(c)
+		if c&colorRGB != 0 {
+			n = nearest256(c.rgb())
+		}
+		if bg {
+			return fmt.Sprintf("48;5;%d", n)
+		}
+		return fmt.Sprintf("38;5;%d", n)
+	}
+
+	n := uint8(c)
+	if c&colorRGB != 0 || n >= 16 {
+		n = nearest16(c.rgb())
+	}
+	base := 30
+	if bg {
+		base = 40
+	}
+	if n >= 8 {
+		// The bright colors.
+		return fmt.Sprint(base + 60 + int(n-8))
+	}
+	return fmt.Sprint(base + int(n))
+}
+
+// Approximate values of the 16 basic colors, as in xterm.
+var basicRGB = [16][3]uint8{
+	{0, 0, 0}, {205, 0, 0}, {0, 205, 0}, {205, 205, 0},
+	{0, 0, 238}, {205, 0, 205}, {0, 205, 205}, {229, 229, 229},
+	{127, 127, 127}, {255, 0, 0}, {0, 255, 0}, {255, 255, 0},
+	{92, 92, 255}, {255, 0, 255}, {0, 255, 255}, {255, 255, 255},
+}
+
+// Levels of the 6x6x6 color cube of the 256 indexed colors.
+var cubeLevels = [6]uint8{0, 95, 135, 175, 215, 255}
+
+func distance(r1, g1, b1, r2, g2, b2 uint8) int {
+	dr, dg, db := int(r1)-int(r2), int(g1)-int(g2), int(b1)-int(b2)
+	return dr*dr + dg*dg + db*db
+}
+
+func nearest16(r, g, b uint8) uint8 {
+	best, bestDist := uint8(0), -1
+	for i, v := range basicRGB {
+		if d := distance(r, g, b, v[0], v[1], v[2]); bestDist < 0 || d < bestDist {
+			best, bestDist = uint8(i), d
+		}
+	}
+	return best
+}
+
+func nearest256(r, g, b uint8) uint8 {
+	best, bestDist := uint8(0), -1
+	for i := 16; i < 256; i++ {
+		cr, cg, cb := Indexed(uint8(i)).rgb()
+		if d := distance(r, g, b, cr, cg, cb); bestDist < 0 || d < bestDist {
+			best, bestDist = uint8(i), d
+		}
+	}
+	return best
+}
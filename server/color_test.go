@@ -0,0 +1,81 @@
+package server
+
+import "testing"
+
+func TestProfileFromTerm(t *testing.T) {
+	tests := []struct {
+		term, colorTerm string
+
+		expected colorProfile
+	}{
+		{term: "", expected: profileMono},
+		{term: "dumb", expected: profileMono},
+		{term: "vt100", expected: profileMono},
+		{term: "vt220", expected: profileMono},
+		{term: "vte-256color", expected: profile256},
+		{term: "vte-256color", colorTerm: "truecolor", expected: profileTrueColor},
+		{term: "xterm", expected: profile16},
+		{term: "screen", expected: profile16},
+		{term: "xterm-256color", expected: profile256},
+		{term: "xterm-256color", colorTerm: "truecolor", expected: profileTrueColor},
+		{term: "xterm-direct", expected: profileTrueColor},
+	}
+
+	for _, test := range tests {
+		if got := profileFromTerm(test.term, test.colorTerm); got != test.expected {
+			t.Errorf("TERM=%q COLORTERM=%q: expected profile %d, got %d", test.term, test.colorTerm, test.expected, got)
+		}
+	}
+}
+
+func TestSGR(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile colorProfile
+		attr    Attr
+
+		expected string
+	}{
+		{
+			name:     "default",
+			profile:  profileTrueColor,
+			expected: "\x1b[0m",
+		},
+		{
+			name:     "truecolor",
+			profile:  profileTrueColor,
+			attr:     Attr{Fg: RGB(215, 175, 0), Bg: Blue},
+			expected: "\x1b[0;38;2;215;175;0;48;5;4m",
+		},
+		{
+			name:     "rgb downsampled to the color cube",
+			profile:  profile256,
+			attr:     Attr{Fg: RGB(215, 175, 0)},
+			expected: "\x1b[0;38;5;178m",
+		},
+		{
+			name:     "rgb downsampled to the basic colors",
+			profile:  profile16,
+			attr:     Attr{Fg: RGB(250, 10, 10), Bg: BrightBlack},
+			expected: "\x1b[0;91;100m",
+		},
+		{
+			name:     "indexed downsampled to the basic colors",
+			profile:  profile16,
+			attr:     Attr{Fg: Indexed(21)},
+			expected: "\x1b[0;34m",
+		},
+		{
+			name:     "monochrome keeps the style only",
+			profile:  profileMono,
+			attr:     Attr{Fg: BrightRed, Style: StyleBold | StyleUnderline},
+			expected: "\x1b[0;1;4m",
+		},
+	}
+
+	for _, test := range tests {
+		if got := test.profile.sgr(test.attr); got != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, got)
+		}
+	}
+}
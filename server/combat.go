@@ -0,0 +1,373 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gothyra/thyra/area"
+	"github.com/gothyra/thyra/game"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// fighter is a player or an NPC in a fight.
+type fighter struct {
+	// key is the nickname of players and the key of NPCs.
+	key    string
+	name   string
+	pc     *game.PC
+	damage *int
+	npc    *npc // nil for players
+}
+
+func playerFighter(p *area.Player) *fighter {
+	return &fighter{key: p.Nickname, name: p.Nickname, pc: &p.PC, damage: &p.Damage}
+}
+
+func npcFighter(n *npc) *fighter {
+	return &fighter{key: n.key, name: n.Name, pc: &n.PC, damage: &n.Damage, npc: n}
+}
+
+// Name implements game.Combatant.
+func (f *fighter) Name() string { return f.name }
+
+// Stats implements game.Combatant.
+func (f *fighter) Stats() *game.PC { return f.pc }
+
+// HP implements game.Combatant.
+func (f *fighter) HP() int { return f.pc.HP - *f.damage }
+
+// Hurt implements game.Combatant.
+func (f *fighter) Hurt(damage int) { *f.damage += damage }
+
+// encounter is a fight in a room, between two or more sides. Every combat
+// round, each fighter strikes a foe in the order of initiative.
+type encounter struct {
+	area, room string
+	fight      *game.Encounter
+	fighters   []*fighter // everyone still in the fight
+	round      *timer
+	// log is everything that happened in the fight.
+	log []game.CombatEvent
+	// dice roll everything in the fight. The seed is logged so that the
+	// fight can be replayed.
+	dice game.Dice
+}
+
+// fighter returns the fighter with the given key, or nil.
+func (e *encounter) fighter(key string) *fighter {
+	for _, f := range e.fighters {
+		if f.key == key {
+			return f
+		}
+	}
+	return nil
+}
+
+// target returns who f strikes next, or nil.
+func (e *encounter) target(f *fighter) *fighter {
+	if target, ok := e.fight.TargetOf(f).(*fighter); ok {
+		return target
+	}
+	return nil
+}
+
+// fightClients returns the online clients of the players in the fight.
+func (s *Server) fightClients(e *encounter) []Client {
+	var clients []Client
+	for _, f := range e.fighters {
+		if f.npc != nil {
+			continue
+		}
+		if c := s.onlineClients[f.key]; c != nil {
+			clients = append(clients, *c)
+		}
+	}
+	return clients
+}
+
+// findFighter returns the player or living NPC of the room of c called name,
+// as they are in their fight if they are fighting. It returns nil if there is
+// none.
+func (s *Server) findFighter(c *Client, name string) *fighter {
+	p := c.Player
+	var found *fighter
+	for _, other := range othersThan(s.OnlineClientsGetByRoom(p.Area, p.Room), c) {
+		if strings.HasPrefix(strings.ToLower(other.Player.Nickname), strings.ToLower(name)) {
+			found = playerFighter(other.Player)
+			break
+		}
+	}
+	if found == nil {
+		n := s.world.findNPC(p.Area, p.Room, name)
+		if n == nil {
+			return nil
+		}
+		found = npcFighter(n)
+	}
+	if e := s.world.fights[found.key]; e != nil {
+		return e.fighter(found.key)
+	}
+	return found
+}
+
+// attackCommand starts a fight, joins one against the target, or turns on
+// another foe in the fight of the player.
+func (s *Server) attackCommand(c *Client, roomsMap map[string]map[string][][]area.Cube, args []string) {
+	p := c.Player
+	if strings.EqualFold(args[0], p.Nickname) {
+		s.godReply(c, roomsMap, "You cannot attack yourself.")
+		return
+	}
+	target := s.findFighter(c, args[0])
+	if target == nil {
+		s.godReply(c, roomsMap, fmt.Sprintf("There is no %s here.", args[0]))
+		return
+	}
+
+	mine, theirs := s.world.fights[p.Nickname], s.world.fights[target.key]
+	switch {
+	case mine != nil && mine == theirs:
+		if !mine.fight.Target(mine.fighter(p.Nickname), target) {
+			s.godReply(c, roomsMap, fmt.Sprintf("%s is on your side.", target.name))
+			return
+		}
+		s.godReply(c, roomsMap, fmt.Sprintf("You turn on %s.", target.name))
+	case mine != nil:
+		s.godReply(c, roomsMap, fmt.Sprintf("You are already fighting %s.", mine.target(mine.fighter(p.Nickname)).name))
+	case theirs != nil:
+		// Side with whoever the target is fighting.
+		f := playerFighter(p)
+		s.godJoinFight(theirs, f, theirs.fight.Side(theirs.fight.TargetOf(target)))
+		theirs.fight.Target(f, target)
+		godMessage(s.OnlineClientsGetByRoom(p.Area, p.Room), msgCombat, fmt.Sprintf("%s joins the fight against %s!", p.Nickname, target.name))
+		s.godPrintRoom(s.fightClients(theirs), roomsMap)
+	default:
+		s.godStartFight(p.Area, p.Room, playerFighter(p), target, roomsMap)
+	}
+}
+
+// assistCommand joins a fight on the side of a player or NPC.
+func (s *Server) assistCommand(c *Client, roomsMap map[string]map[string][][]area.Cube, args []string) {
+	p := c.Player
+	if e := s.world.fights[p.Nickname]; e != nil {
+		s.godReply(c, roomsMap, fmt.Sprintf("You are already fighting %s.", e.target(e.fighter(p.Nickname)).name))
+		return
+	}
+	ally := s.findFighter(c, args[0])
+	if ally == nil {
+		s.godReply(c, roomsMap, fmt.Sprintf("There is no %s here.", args[0]))
+		return
+	}
+	e := s.world.fights[ally.key]
+	if e == nil {
+		s.godReply(c, roomsMap, fmt.Sprintf("%s is not fighting.", ally.name))
+		return
+	}
+
+	s.godJoinFight(e, playerFighter(p), e.fight.Side(ally))
+	godMessage(s.OnlineClientsGetByRoom(p.Area, p.Room), msgCombat, fmt.Sprintf("%s joins the fight on the side of %s!", p.Nickname, ally.name))
+	s.godPrintRoom(s.fightClients(e), roomsMap)
+}
+
+// fleeCommand leaves a fight by running to a free cube next to the player,
+// picked at random.
+func (s *Server) fleeCommand(c *Client, roomsMap map[string]map[string][][]area.Cube, _ []string) {
+	p := c.Player
+	e := s.world.fights[p.Nickname]
+	if e == nil {
+		s.godReply(c, roomsMap, "You are not fighting.")
+		return
+	}
+
+	online := s.OnlineClientsGetByRoom(p.Area, p.Room)
+	exits := area.FindExits(roomsMap[p.Area][p.Room], p.Area, p.Room, p.Position)
+	var ways []area.Direction
+	for _, d := range area.Directions {
+		to, ok := exits[d]
+		if !ok {
+			continue
+		}
+		if free, _ := isCubeAvailable(*c, online, s.world, to.Area, to.Room, to.CubeID); free {
+			ways = append(ways, d)
+		}
+	}
+	if len(ways) == 0 {
+		s.godReply(c, roomsMap, "There is nowhere to flee!")
+		return
+	}
+	way := ways[e.dice.Roll(len(ways))-1]
+
+	log.Info(fmt.Sprintf("[%s] flees %s from the fight in %s/%s.", p.Nickname, way, e.area, e.room))
+	godMessage([]Client{*c}, msgCombat, fmt.Sprintf("You flee %s!", way))
+	s.godWithdraw(e, e.fighter(p.Nickname), fmt.Sprintf("%s flees %s!", p.Nickname, way))
+	if e.fight.Over() {
+		s.godEndFight(e)
+	}
+	s.godMove(c, roomsMap, way)
+}
+
+// godStartFight starts a fight between attacker and target in a room. The
+// other NPCs of the room that are not fighting side with an NPC target.
+func (s *Server) godStartFight(areaName, roomName string, attacker, target *fighter, roomsMap map[string]map[string][][]area.Cube) {
+	seed := int64(s.world.dice.Roll(1<<31 - 1))
+	e := &encounter{area: areaName, room: roomName, dice: s.world.fightDice(seed)}
+	e.fight = game.NewEncounter(e.dice)
+	s.godJoinFight(e, attacker, 0)
+	s.godJoinFight(e, target, 1)
+	e.fight.Target(attacker, target)
+	var pack []string
+	if target.npc != nil {
+		for _, n := range s.world.npcsIn(areaName, roomName) {
+			if n != target.npc && s.world.fights[n.key] == nil {
+				s.godJoinFight(e, npcFighter(n), 1)
+				pack = append(pack, n.Name)
+			}
+		}
+	}
+	e.round = s.scheduler.every("combat round", s.config.CombatRound.Duration, func(time.Time) {
+		s.godRound(e, roomsMap)
+	})
+	log.Info(fmt.Sprintf("[%s] attacks [%s] in %s/%s, dice seed %d.", attacker.name, target.name, e.area, e.room, seed))
+
+	room := s.OnlineClientsGetByRoom(e.area, e.room)
+	fighting := s.fightClients(e)
+	var watching []Client
+	for _, c := range room {
+		if e.fighter(c.Player.Nickname) == nil {
+			watching = append(watching, c)
+		}
+	}
+	godMessage(watching, msgCombat, fmt.Sprintf("%s attacks %s!", attacker.name, target.name))
+	for _, c := range fighting {
+		if c.Player.Nickname == attacker.key {
+			godMessage([]Client{c}, msgCombat, fmt.Sprintf("You attack %s!", target.name))
+		} else {
+			godMessage([]Client{c}, msgCombat, fmt.Sprintf("%s attacks you!", attacker.name))
+		}
+	}
+	for _, name := range pack {
+		godMessage(room, msgCombat, fmt.Sprintf("%s joins the fight!", name))
+	}
+	godMessage(fighting, msgCombat, fmt.Sprintf("%s strikes first.", e.fight.Order()[0].Name()))
+	s.godPrintRoom(room, roomsMap)
+}
+
+// godJoinFight adds a fighter to a side of the encounter. They strike from
+// the next round on.
+func (s *Server) godJoinFight(e *encounter, f *fighter, side int) {
+	initiative := e.fight.Join(f, side)
+	e.fighters = append(e.fighters, f)
+	s.world.fights[f.key] = e
+	log.Info(fmt.Sprintf("[%s] joins side %d of the fight in %s/%s with initiative %d.", f.name, side, e.area, e.room, initiative))
+}
+
+// godRound is a combat round of the encounter. Every fighter strikes a foe,
+// and the defeated ones drop out, until a single side is left.
+// The attacks are told to the players fighting, a defeat to the whole room.
+func (s *Server) godRound(e *encounter, roomsMap map[string]map[string][][]area.Cube) {
+	events := e.fight.NextRound()
+	e.log = append(e.log, events...)
+	for _, event := range events {
+		if event.Kind == game.EventDefeat {
+			godMessage(s.OnlineClientsGetByRoom(e.area, e.room), msgCombat, s.narrator.Narrate(event))
+		} else {
+			godMessage(s.fightClients(e), msgCombat, s.narrator.Narrate(event))
+		}
+	}
+
+	var defeated []*fighter
+	for _, f := range e.fighters {
+		if f.HP() <= 0 {
+			defeated = append(defeated, f)
+		}
+	}
+	for _, f := range defeated {
+		s.godWithdraw(e, f, "")
+		s.godDefeat(f, e, roomsMap)
+	}
+	if e.fight.Over() {
+		s.godEndFight(e)
+	}
+
+	for _, c := range s.fightClients(e) {
+		s.autosave.markDirty(c.Player)
+	}
+	s.godPrintRoom(s.fightClients(e), roomsMap)
+}
+
+// godWithdraw takes a fighter out of the encounter, telling the others why
+// unless msg is empty. The caller ends the fight once a single side is left.
+func (s *Server) godWithdraw(e *encounter, f *fighter, msg string) {
+	e.fight.Leave(f)
+	for i, other := range e.fighters {
+		if other == f {
+			e.fighters = append(e.fighters[:i], e.fighters[i+1:]...)
+			break
+		}
+	}
+	delete(s.world.fights, f.key)
+	if msg != "" {
+		godMessage(s.fightClients(e), msgCombat, msg)
+	}
+}
+
+// godEndFight stops the encounter. Whoever is left is told that the fight is
+// over.
+func (s *Server) godEndFight(e *encounter) {
+	e.round.cancel()
+	log.Info(fmt.Sprintf("The fight in %s/%s is over after %d rounds.", e.area, e.room, e.fight.Round))
+	godMessage(s.fightClients(e), msgCombat, "The fight is over.")
+	for _, f := range e.fighters {
+		delete(s.world.fights, f.key)
+	}
+}
+
+// godLeaveFight takes a player who left the game out of their fight.
+func (s *Server) godLeaveFight(nickname string, roomsMap map[string]map[string][][]area.Cube) {
+	e := s.world.fights[nickname]
+	if e == nil {
+		return
+	}
+	s.godWithdraw(e, e.fighter(nickname), fmt.Sprintf("%s is gone.", nickname))
+	if e.fight.Over() {
+		s.godEndFight(e)
+	}
+	s.godPrintRoom(s.fightClients(e), roomsMap)
+}
+
+// godDefeat takes a defeated fighter out of the room. NPCs come back after a
+// while; players wake up where new players start, barely alive.
+func (s *Server) godDefeat(f *fighter, e *encounter, roomsMap map[string]map[string][][]area.Cube) {
+	room := s.OnlineClientsGetByRoom(e.area, e.room)
+	log.Info(fmt.Sprintf("[%s] was defeated in %s/%s in round %d.", f.name, e.area, e.room, e.fight.Round))
+
+	if n := f.npc; n != nil {
+		n.dead = true
+		s.godPrintRoom(room, roomsMap)
+		n.respawn = s.scheduler.after("respawn", s.config.NPCRespawn.Duration, func(time.Time) {
+			n.dead, n.Damage, n.Position = false, 0, n.home
+			here := s.OnlineClientsGetByRoom(n.Area, n.Room)
+			godMessage(here, msgRoom, fmt.Sprintf("%s appears.", n.Name))
+			s.godPrintRoom(here, roomsMap)
+		})
+		return
+	}
+
+	c := s.onlineClients[f.key]
+	if c == nil {
+		return
+	}
+	p := c.Player
+	p.PreviousArea, p.PreviousRoom = p.Area, p.Room
+	p.Area, p.Room, p.Position = s.config.StartArea, s.config.StartRoom, s.config.StartPosition
+	p.Damage = p.HP - 1
+	s.autosave.markDirty(p)
+	s.godPrintRoom(othersThan(room, c), roomsMap)
+
+	here := s.OnlineClientsGetByRoom(p.Area, p.Room)
+	godMessage(othersThan(here, c), msgRoom, fmt.Sprintf("%s is carried in, barely alive.", p.Nickname))
+	godMessage([]Client{*c}, msgCombat, fmt.Sprintf("You wake up in %s, barely alive.", p.Room))
+	s.godPrintRoom(here, roomsMap)
+}
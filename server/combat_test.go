@@ -0,0 +1,454 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gothyra/thyra/area"
+	"github.com/gothyra/thyra/game"
+)
+
+// scriptedDice roll the given numbers, in order.
+type scriptedDice struct {
+	t     *testing.T
+	rolls []int
+}
+
+func (d *scriptedDice) Roll(sides int) int {
+	if len(d.rolls) == 0 {
+		d.t.Fatalf("ran out of rolls for a d%d", sides)
+	}
+	roll := d.rolls[0]
+	d.rolls = d.rolls[1:]
+	return roll
+}
+
+func (d *scriptedDice) D20() int { return d.Roll(20) }
+
+func (d *scriptedDice) Advantage() int {
+	first, second := d.D20(), d.D20()
+	if second > first {
+		return second
+	}
+	return first
+}
+
+func (d *scriptedDice) DropLowest(n, sides, drop int) int {
+	total := 0
+	for i := 0; i < n; i++ {
+		total += d.Roll(sides)
+	}
+	return total
+}
+
+func (d *scriptedDice) Eval(expr game.Expr) int {
+	total := expr.Mod
+	for i := 0; i < expr.N; i++ {
+		total += d.Roll(expr.Sides)
+	}
+	return total
+}
+
+// combatTest is a server with a single room, the Cage of the Arena:
+//
+//	1 2 3
+//	4 5 6
+//	7 8 9
+//
+// Every fight in it rolls the scripted dice.
+type combatTest struct {
+	s        *Server
+	roomsMap map[string]map[string][][]area.Cube
+	start    time.Time
+}
+
+func newCombatTest(t *testing.T, npcs []area.NPC, rolls ...int) *combatTest {
+	areas := map[string]area.Area{
+		"Arena": {Name: "Arena", Rooms: map[string]area.Room{"Cage": {Name: "Cage", NPCs: npcs}}},
+	}
+	start := time.Now()
+	s := &Server{
+		config:        DefaultConfig(),
+		onlineClients: make(map[string]*Client),
+		commands:      newCommandRegistry(gameCommands...),
+		scheduler:     newScheduler(start),
+		world:         newWorld(areas, 1),
+		autosave:      newAutosave(nil),
+		narrator:      mustNarrator(t),
+		Areas:         areas,
+	}
+	dice := &scriptedDice{t: t, rolls: rolls}
+	s.world.fightDice = func(int64) game.Dice { return dice }
+
+	grid := [][]area.Cube{
+		{{ID: "1"}, {ID: "4"}, {ID: "7"}},
+		{{ID: "2"}, {ID: "5"}, {ID: "8"}},
+		{{ID: "3"}, {ID: "6"}, {ID: "9"}},
+	}
+	roomsMap := map[string]map[string][][]area.Cube{"Arena": {"Cage": grid}}
+	return &combatTest{s: s, roomsMap: roomsMap, start: start}
+}
+
+func mustNarrator(t *testing.T) game.Narrator {
+	n, err := game.NewTemplateNarrator(game.NarrationSet{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// join puts a player with 10 HP, AC 10 and a fist on a cube of the Cage.
+func (ct *combatTest) join(nick, position string) *Client {
+	p := &area.Player{
+		Nickname: nick,
+		Area:     "Arena",
+		Room:     "Cage",
+		Position: position,
+		PC:       game.PC{HP: 10, AC: 10, STR: 10, DEX: 10, Weapon: "fist", Weapondie: 3},
+	}
+	c := &Client{Name: nick, Player: p, messages: newMessageLog(50)}
+	ct.s.onlineClients[nick] = c
+	return c
+}
+
+// command makes c type line.
+func (ct *combatTest) command(c *Client, line string) {
+	ct.s.godHandle(Event{Type: EventCommand, Client: c, Payload: CommandEvent{Line: line}}, ct.roomsMap)
+}
+
+// wait runs the timers due after d since the start.
+func (ct *combatTest) wait(d time.Duration) {
+	ct.s.godHandle(Event{Type: EventTick, Payload: TickEvent{Time: ct.start.Add(d)}}, ct.roomsMap)
+}
+
+// rounds fights n combat rounds.
+func (ct *combatTest) rounds(n int) {
+	for i := 1; i <= n; i++ {
+		ct.wait(time.Duration(i) * ct.s.config.CombatRound.Duration)
+	}
+}
+
+func lastMessage(c *Client) string {
+	entries := c.messages.entries
+	if len(entries) == 0 {
+		return ""
+	}
+	return entries[len(entries)-1].text
+}
+
+func TestAttack(t *testing.T) {
+	rat := area.NPC{Name: "Giant Rat", Position: "4", PC: game.PC{HP: 2, AC: 10, STR: 10, DEX: 10, Weapon: "bite", Weapondie: 4}}
+
+	tests := []struct {
+		name  string
+		lines []string
+		// hurt is the damage Bob took before the fight.
+		hurt   int
+		rounds int
+		// respawn waits for the defeated NPCs to come back.
+		respawn bool
+		rolls   []int
+
+		last     string
+		watched  string // the last message of Mike, who watches
+		fighting bool
+		at       string // where Bob is
+		damage   int
+		ratDead  bool
+	}{
+		{
+			name:  "nobody there",
+			lines: []string{"attack ghost"},
+			last:  "There is no ghost here.",
+			at:    "Arena/Cage/5",
+		},
+		{
+			name:  "yourself",
+			lines: []string{"attack bob"},
+			last:  "You cannot attack yourself.",
+			at:    "Arena/Cage/5",
+		},
+		{
+			name:     "NPC by a word of its name",
+			lines:    []string{"attack rat"},
+			rolls:    []int{12, 5},
+			last:     "Bob strikes first.",
+			watched:  "Bob attacks Giant Rat!",
+			fighting: true,
+			at:       "Arena/Cage/5",
+		},
+		{
+			name:     "no moving while fighting",
+			lines:    []string{"attack rat", "east"},
+			rolls:    []int{12, 5},
+			last:     "You are fighting!",
+			watched:  "Bob attacks Giant Rat!",
+			fighting: true,
+			at:       "Arena/Cage/5",
+		},
+		{
+			name:  "NPCs block the way",
+			lines: []string{"west"},
+			last:  "Giant Rat is blocking the way",
+			at:    "Arena/Cage/5",
+		},
+		{
+			name:    "NPC defeated",
+			lines:   []string{"attack rat"},
+			rounds:  1,
+			rolls:   []int{12, 5, 15, 3},
+			last:    "The fight is over.",
+			watched: "Giant Rat is defeated!",
+			at:      "Arena/Cage/5",
+			ratDead: true,
+		},
+		{
+			name:    "NPC back after a while",
+			lines:   []string{"attack rat"},
+			rounds:  1,
+			respawn: true,
+			rolls:   []int{12, 5, 15, 3},
+			last:    "Giant Rat appears.",
+			watched: "Giant Rat appears.",
+			at:      "Arena/Cage/5",
+		},
+		{
+			name:    "player defeated",
+			lines:   []string{"attack rat"},
+			hurt:    7,
+			rounds:  1,
+			rolls:   []int{5, 12, 15, 4},
+			last:    "You wake up in Inn, barely alive.",
+			watched: "Bob is defeated!",
+			at:      "City/Inn/1",
+			damage:  9,
+		},
+	}
+
+	for _, test := range tests {
+		ct := newCombatTest(t, []area.NPC{rat}, test.rolls...)
+		bob, mike := ct.join("Bob", "5"), ct.join("Mike", "9")
+		bob.Player.Damage = test.hurt
+
+		for i, line := range test.lines {
+			ct.command(bob, line)
+			if i == 0 {
+				ct.rounds(test.rounds)
+			}
+		}
+		if test.respawn {
+			ct.wait(time.Duration(test.rounds)*ct.s.config.CombatRound.Duration + ct.s.config.NPCRespawn.Duration)
+		}
+
+		p := bob.Player
+		if got := lastMessage(bob); got != test.last {
+			t.Errorf("%s: expected Bob's last message to be %q, got %q", test.name, test.last, got)
+		}
+		if got := lastMessage(mike); got != test.watched {
+			t.Errorf("%s: expected Mike's last message to be %q, got %q", test.name, test.watched, got)
+		}
+		if fighting := ct.s.world.fights["Bob"] != nil; fighting != test.fighting {
+			t.Errorf("%s: expected fighting %t, got %t", test.name, test.fighting, fighting)
+		}
+		if at := p.Area + "/" + p.Room + "/" + p.Position; at != test.at {
+			t.Errorf("%s: expected Bob at %s, got %s", test.name, test.at, at)
+		}
+		if p.Damage != test.damage {
+			t.Errorf("%s: expected Bob to have taken %d damage, got %d", test.name, test.damage, p.Damage)
+		}
+		if n := ct.s.world.npcs[0]; n.dead != test.ratDead || (!n.dead && test.respawn && n.Damage != 0) {
+			t.Errorf("%s: expected the rat dead %t and healed once back, got dead %t with %d damage", test.name, test.ratDead, n.dead, n.Damage)
+		}
+	}
+}
+
+func TestDefeatNamesake(t *testing.T) {
+	rat := area.NPC{Name: "Giant Rat", Position: "4", PC: game.PC{HP: 2, AC: 10, STR: 10, DEX: 10, Weapon: "bite", Weapondie: 4}}
+	ct := newCombatTest(t, []area.NPC{rat}, 5, 12, 15, 4)
+	bob, namesake := ct.join("Bob", "5"), ct.join("bob", "9")
+	bob.Player.Damage = 7
+	ct.command(bob, "attack rat")
+	ct.rounds(1)
+
+	if p := bob.Player; p.Area+"/"+p.Room != "City/Inn" || p.Damage != 9 {
+		t.Errorf("expected Bob to wake up in City/Inn with 9 damage, got %s/%s with %d", p.Area, p.Room, p.Damage)
+	}
+	if p := namesake.Player; p.Area+"/"+p.Room+"/"+p.Position != "Arena/Cage/9" || p.Damage != 0 {
+		t.Errorf("expected bob to stay unhurt in Arena/Cage/9, got %s/%s/%s with %d damage", p.Area, p.Room, p.Position, p.Damage)
+	}
+	if got := lastMessage(namesake); got != "Bob is defeated!" {
+		t.Errorf("expected bob to watch Bob being defeated, got %q", got)
+	}
+}
+
+// countMessages returns how many times c was told text.
+func countMessages(c *Client, text string) int {
+	n := 0
+	for _, e := range c.messages.entries {
+		if e.text == text {
+			n++
+		}
+	}
+	return n
+}
+
+func TestFightOverOnce(t *testing.T) {
+	npcs := []area.NPC{
+		{Name: "Giant Rat", Position: "4", PC: game.PC{HP: 2}},
+		{Name: "Bat", Position: "1", PC: game.PC{HP: 2}},
+	}
+	// Bob and Mike strike first, each felling one of the two NPCs in the
+	// same round.
+	ct := newCombatTest(t, npcs, 15, 5, 4, 14, 15, 3, 15, 3)
+	bob, mike := ct.join("Bob", "5"), ct.join("Mike", "9")
+	ct.command(bob, "attack rat")
+	ct.command(mike, "assist bob")
+	ct.rounds(1)
+
+	for _, c := range []*Client{bob, mike} {
+		if n := countMessages(c, "The fight is over."); n != 1 {
+			t.Errorf("expected %s to be told once that the fight is over, got %d times", c.Name, n)
+		}
+	}
+	for _, n := range ct.s.world.npcs {
+		if !n.dead {
+			t.Errorf("expected %s to be defeated", n.Name)
+		}
+	}
+	if len(ct.s.world.fights) != 0 {
+		t.Errorf("expected nobody to be fighting, got %d fighters", len(ct.s.world.fights))
+	}
+}
+
+func TestGroupFight(t *testing.T) {
+	npcs := []area.NPC{
+		{Name: "Giant Rat", Position: "4", PC: game.PC{HP: 2}},
+		{Name: "Bat", Position: "1", PC: game.PC{HP: 2}},
+	}
+
+	type step struct{ who, line string }
+	tests := []struct {
+		name  string
+		steps []step
+		rolls []int
+
+		bobLast, mikeLast string
+		// sides are the sides of everyone fighting, by name.
+		sides map[string]int
+		// target is who Bob strikes next.
+		target string
+		at     string // where Bob is
+	}{
+		{
+			name:     "NPCs side with the target",
+			steps:    []step{{"Bob", "attack rat"}},
+			rolls:    []int{12, 5, 4},
+			bobLast:  "Bob strikes first.",
+			mikeLast: "Bat joins the fight!",
+			sides:    map[string]int{"Bob": 0, "Giant Rat": 1, "Bat": 1},
+			target:   "Giant Rat",
+			at:       "Arena/Cage/5",
+		},
+		{
+			name:     "assist",
+			steps:    []step{{"Bob", "attack rat"}, {"Mike", "assist bob"}},
+			rolls:    []int{12, 5, 4, 14},
+			bobLast:  "Mike joins the fight on the side of Bob!",
+			mikeLast: "Mike joins the fight on the side of Bob!",
+			sides:    map[string]int{"Bob": 0, "Mike": 0, "Giant Rat": 1, "Bat": 1},
+			target:   "Giant Rat",
+			at:       "Arena/Cage/5",
+		},
+		{
+			name:     "attack someone fighting",
+			steps:    []step{{"Bob", "attack rat"}, {"Mike", "attack bat"}},
+			rolls:    []int{12, 5, 4, 14},
+			bobLast:  "Mike joins the fight against Bat!",
+			mikeLast: "Mike joins the fight against Bat!",
+			sides:    map[string]int{"Bob": 0, "Mike": 0, "Giant Rat": 1, "Bat": 1},
+			target:   "Giant Rat",
+			at:       "Arena/Cage/5",
+		},
+		{
+			name:     "turn on another foe",
+			steps:    []step{{"Bob", "attack rat"}, {"Bob", "attack bat"}},
+			rolls:    []int{12, 5, 4},
+			bobLast:  "You turn on Bat.",
+			mikeLast: "Bat joins the fight!",
+			sides:    map[string]int{"Bob": 0, "Giant Rat": 1, "Bat": 1},
+			target:   "Bat",
+			at:       "Arena/Cage/5",
+		},
+		{
+			name:     "attack an ally",
+			steps:    []step{{"Bob", "attack rat"}, {"Mike", "assist bob"}, {"Bob", "attack mike"}},
+			rolls:    []int{12, 5, 4, 14},
+			bobLast:  "Mike is on your side.",
+			mikeLast: "Mike joins the fight on the side of Bob!",
+			sides:    map[string]int{"Bob": 0, "Mike": 0, "Giant Rat": 1, "Bat": 1},
+			target:   "Giant Rat",
+			at:       "Arena/Cage/5",
+		},
+		{
+			name:     "assist someone not fighting",
+			steps:    []step{{"Mike", "assist bob"}},
+			mikeLast: "Bob is not fighting.",
+			sides:    map[string]int{},
+			at:       "Arena/Cage/5",
+		},
+		{
+			// Mike and the NPCs block three of the eight ways out: Bob
+			// can go north, northeast, east, south or southwest.
+			name:     "flee",
+			steps:    []step{{"Bob", "attack rat"}, {"Bob", "flee"}},
+			rolls:    []int{12, 5, 4, 3},
+			bobLast:  "You flee east!",
+			mikeLast: "Bat joins the fight!",
+			sides:    map[string]int{},
+			at:       "Arena/Cage/6",
+		},
+		{
+			name:    "flee without fighting",
+			steps:   []step{{"Bob", "flee"}},
+			bobLast: "You are not fighting.",
+			sides:   map[string]int{},
+			at:      "Arena/Cage/5",
+		},
+	}
+
+	for _, test := range tests {
+		ct := newCombatTest(t, npcs, test.rolls...)
+		clients := map[string]*Client{"Bob": ct.join("Bob", "5"), "Mike": ct.join("Mike", "9")}
+		for _, step := range test.steps {
+			ct.command(clients[step.who], step.line)
+		}
+
+		bob, mike := clients["Bob"], clients["Mike"]
+		if got := lastMessage(bob); got != test.bobLast {
+			t.Errorf("%s: expected Bob's last message to be %q, got %q", test.name, test.bobLast, got)
+		}
+		if got := lastMessage(mike); got != test.mikeLast {
+			t.Errorf("%s: expected Mike's last message to be %q, got %q", test.name, test.mikeLast, got)
+		}
+
+		sides := make(map[string]int)
+		for key, e := range ct.s.world.fights {
+			f := e.fighter(key)
+			sides[f.name] = e.fight.Side(f)
+		}
+		if !reflect.DeepEqual(sides, test.sides) {
+			t.Errorf("%s: expected the sides %v, got %v", test.name, test.sides, sides)
+		}
+		target := ""
+		if e := ct.s.world.fights["Bob"]; e != nil {
+			target = e.target(e.fighter("Bob")).name
+		}
+		if target != test.target {
+			t.Errorf("%s: expected Bob to strike %q, got %q", test.name, test.target, target)
+		}
+		if p := bob.Player; p.Area+"/"+p.Room+"/"+p.Position != test.at {
+			t.Errorf("%s: expected Bob at %s, got %s/%s/%s", test.name, test.at, p.Area, p.Room, p.Position)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gothyra/thyra/area"
+)
+
+// permission is who may use a command.
+type permission int
+
+const (
+	permPlayer permission = iota
+	permAdmin
+)
+
+// argSpec describes an argument of a command. A rest argument takes the rest
+// of the line as it was typed and must come last.
+type argSpec struct {
+	name     string
+	optional bool
+	rest     bool
+}
+
+// commandHandler runs a command with its parsed arguments. Missing optional
+// arguments are empty strings.
+type commandHandler func(s *Server, c *Client, roomsMap map[string]map[string][][]area.Cube, args []string)
+
+// command is something players can type at the prompt.
+type command struct {
+	name    string
+	aliases []string
+	args    []argSpec
+	perm    permission
+	help    string
+	handler commandHandler
+}
+
+// usage returns how the command is typed, such as "tell <player> <message>".
+func (cmd *command) usage() string {
+	parts := []string{cmd.name}
+	for _, arg := range cmd.args {
+		if arg.optional {
+			parts = append(parts, "["+arg.name+"]")
+		} else {
+			parts = append(parts, "<"+arg.name+">")
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// commandRegistry finds the commands typed at the prompt.
+type commandRegistry struct {
+	commands []*command
+	byName   map[string]*command // names and aliases
+}
+
+func newCommandRegistry(commands ...*command) *commandRegistry {
+	r := &commandRegistry{byName: make(map[string]*command)}
+	for _, cmd := range commands {
+		r.commands = append(r.commands, cmd)
+		r.byName[cmd.name] = cmd
+		for _, alias := range cmd.aliases {
+			r.byName[alias] = cmd
+		}
+	}
+	sort.Slice(r.commands, func(i, j int) bool {
+		return r.commands[i].name < r.commands[j].name
+	})
+	return r
+}
+
+// resolve finds the command for a typed word: a name, an alias or the
+// unambiguous beginning of a name. A beginning shared by names that all start
+// with one of them, such as north, northeast and northwest, is that one.
+// Only commands that allowed accepts are found. The error tells the player
+// what went wrong.
+func (r *commandRegistry) resolve(word string, allowed func(*command) bool) (*command, error) {
+	word = strings.ToLower(word)
+	if cmd, ok := r.byName[word]; ok && allowed(cmd) {
+		return cmd, nil
+	}
+
+	var matches []string
+	var found []*command
+	for _, cmd := range r.commands {
+		if allowed(cmd) && strings.HasPrefix(cmd.name, word) {
+			matches = append(matches, cmd.name)
+			found = append(found, cmd)
+		}
+	}
+	for _, cmd := range found {
+		if prefixOfAll(cmd.name, matches) {
+			return cmd, nil
+		}
+	}
+	if len(matches) > 1 {
+		return nil, fmt.Errorf("%q could be %s.", word, orList(matches))
+	}
+
+	// Suggest the commands that are a typo or two away.
+	best := 3
+	var suggestions []string
+	for _, cmd := range r.commands {
+		if !allowed(cmd) {
+			continue
+		}
+		d := editDistance(word, cmd.name)
+		switch {
+		case d < best:
+			best, suggestions = d, []string{cmd.name}
+		case d == best:
+			suggestions = append(suggestions, cmd.name)
+		}
+	}
+	if len(suggestions) > 0 {
+		return nil, fmt.Errorf("Unknown command %q. Did you mean %s?", word, orList(suggestions))
+	}
+	return nil, fmt.Errorf("Unknown command %q. Type help for a list of commands.", word)
+}
+
+// prefixOfAll tells whether every name starts with prefix.
+func prefixOfAll(prefix string, names []string) bool {
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// parse splits a line typed at the prompt into a command and its arguments.
+// It returns a nil command for an empty line.
+func (r *commandRegistry) parse(line string, allowed func(*command) bool) (*command, []string, error) {
+	word, pos, err := nextToken(line, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if word == "" {
+		return nil, nil, nil
+	}
+	cmd, err := r.resolve(word, allowed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	usage := fmt.Errorf("Usage: %s", cmd.usage())
+	args := make([]string, len(cmd.args))
+	for i, spec := range cmd.args {
+		var arg string
+		if spec.rest {
+			arg, pos = strings.TrimSpace(line[pos:]), len(line)
+		} else if arg, pos, err = nextToken(line, pos); err != nil {
+			return nil, nil, err
+		}
+		if arg == "" && !spec.optional {
+			return nil, nil, usage
+		}
+		args[i] = arg
+	}
+	if extra, _, _ := nextToken(line, pos); extra != "" {
+		return nil, nil, usage
+	}
+	return cmd, args, nil
+}
+
+// nextToken returns the word of line that starts at or after pos, and where
+// it ends. Words are separated by spaces; quotes keep spaces in a word.
+func nextToken(line string, pos int) (string, int, error) {
+	for pos < len(line) && line[pos] == ' ' {
+		pos++
+	}
+	var word []byte
+	var quote byte
+	for ; pos < len(line); pos++ {
+		ch := line[pos]
+		switch {
+		case quote != 0 && ch == quote:
+			quote = 0
+		case quote != 0:
+			word = append(word, ch)
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == ' ':
+			return string(word), pos, nil
+		default:
+			word = append(word, ch)
+		}
+	}
+	if quote != 0 {
+		return "", pos, errors.New("A quote is not closed.")
+	}
+	return string(word), pos, nil
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// orList joins words as in "a, b or c".
+func orList(words []string) string {
+	if len(words) == 1 {
+		return words[0]
+	}
+	return strings.Join(words[:len(words)-1], ", ") + " or " + words[len(words)-1]
+}
+
+// allowed reports whether the client may use the command.
+func (s *Server) allowed(c *Client, cmd *command) bool {
+	return cmd.perm == permPlayer || s.isAdmin(c.Player)
+}
+
+// godCommand runs the command typed by the client. An empty line only redraws
+// the client's screen. It returns the error shown to the client when the line
+// is not a valid command.
+func (s *Server) godCommand(c *Client, roomsMap map[string]map[string][][]area.Cube, line string) error {
+	cmd, args, err := s.commands.parse(line, func(cmd *command) bool { return s.allowed(c, cmd) })
+	switch {
+	case err != nil:
+		s.godReply(c, roomsMap, err.Error())
+	case cmd == nil:
+		s.godPrintRoom([]Client{*c}, roomsMap)
+	default:
+		cmd.handler(s, c, roomsMap, args)
+	}
+	return err
+}
+
+// gameCommands are the commands of the game.
+var gameCommands = []*command{
+	{name: "east", aliases: []string{"e"}, help: "Move east.", handler: move(area.East)},
+	{name: "west", aliases: []string{"w"}, help: "Move west.", handler: move(area.West)},
+	{name: "north", aliases: []string{"n"}, help: "Move north.", handler: move(area.North)},
+	{name: "south", aliases: []string{"s"}, help: "Move south.", handler: move(area.South)},
+	{name: "northeast", aliases: []string{"ne"}, help: "Move northeast.", handler: move(area.NorthEast)},
+	{name: "northwest", aliases: []string{"nw"}, help: "Move northwest.", handler: move(area.NorthWest)},
+	{name: "southeast", aliases: []string{"se"}, help: "Move southeast.", handler: move(area.SouthEast)},
+	{name: "southwest", aliases: []string{"sw"}, help: "Move southwest.", handler: move(area.SouthWest)},
+	{name: "up", aliases: []string{"u"}, help: "Go up, such as up the stairs.", handler: move(area.Up)},
+	{name: "down", aliases: []string{"d"}, help: "Go down, such as down the stairs.", handler: move(area.Down)},
+	{name: "attack", aliases: []string{"kill"}, args: []argSpec{{name: "target"}}, help: "Fight a player or NPC in the room, or turn on them in your fight.", handler: (*Server).attackCommand},
+	{name: "assist", args: []argSpec{{name: "ally"}}, help: "Join a fight on the side of a player or NPC.", handler: (*Server).assistCommand},
+	{name: "flee", help: "Run away from your fight.", handler: (*Server).fleeCommand},
+	{name: "quit", help: "Leave the game.", handler: (*Server).quitCommand},
+
+	{name: "say", args: []argSpec{{name: "message", rest: true}}, help: "Talk to the room.", handler: (*Server).sayCommand},
+	{name: "emote", args: []argSpec{{name: "action", rest: true}}, help: "Show the room what you do.", handler: (*Server).emoteCommand},
+	{name: "shout", args: []argSpec{{name: "message", rest: true}}, help: "Talk to the whole area.", handler: (*Server).shoutCommand},
+	{name: "tell", args: []argSpec{{name: "player"}, {name: "message", rest: true}}, help: "Talk to a player in private.", handler: (*Server).tellCommand},
+	{name: "reply", args: []argSpec{{name: "message", rest: true}}, help: "Answer the last tell.", handler: (*Server).replyCommand},
+	{name: "ignore", args: []argSpec{{name: "player", optional: true}}, help: "Ignore a player, or stop ignoring them.", handler: replies((*Server).ignoreCommand)},
+
+	{name: "layout", args: []argSpec{{name: "name", optional: true}}, help: "Switch the screen layout.", handler: replies((*Server).layoutCommand)},
+	{name: "keys", args: []argSpec{{name: "add|revoke", optional: true}, {name: "key", optional: true, rest: true}}, help: "Manage the SSH keys of your player.", handler: replies((*Server).keysCommand)},
+	{name: "rotatekeys", perm: permAdmin, help: "Replace the host keys of the server.", handler: replies(func(s *Server, c *Client, _ []string) string {
+		return s.rotateKeysCommand(c)
+	})},
+	{name: "help", args: []argSpec{{name: "command", optional: true}}, help: "List the commands, or explain one.", handler: replies((*Server).helpCommand)},
+}
+
+// move returns the handler of a movement command.
+func move(direction area.Direction) commandHandler {
+	return func(s *Server, c *Client, roomsMap map[string]map[string][][]area.Cube, _ []string) {
+		s.godMove(c, roomsMap, direction)
+	}
+}
+
+// replies turns a command that returns the text to show to the client into
+// a handler. Empty arguments are left out.
+func replies(run func(s *Server, c *Client, args []string) string) commandHandler {
+	return func(s *Server, c *Client, roomsMap map[string]map[string][][]area.Cube, args []string) {
+		var given []string
+		for _, arg := range args {
+			if arg != "" {
+				given = append(given, arg)
+			}
+		}
+		s.godReply(c, roomsMap, run(s, c, given))
+	}
+}
+
+func (s *Server) quitCommand(c *Client, roomsMap map[string]map[string][][]area.Cube, _ []string) {
+	c.conn.EraseScreen()
+	s.godDisconnect(c, roomsMap)
+}
+
+// helpCommand lists the commands the client can use, or shows how to use
+// one of them.
+func (s *Server) helpCommand(c *Client, args []string) string {
+	allowed := func(cmd *command) bool { return s.allowed(c, cmd) }
+	if len(args) > 0 {
+		cmd, err := s.commands.resolve(args[0], allowed)
+		if err != nil {
+			return err.Error()
+		}
+		text := fmt.Sprintf("%s: %s", cmd.usage(), cmd.help)
+		if len(cmd.aliases) > 0 {
+			text += fmt.Sprintf(" Also: %s.", strings.Join(cmd.aliases, ", "))
+		}
+		return text
+	}
+
+	var names []string
+	for _, cmd := range s.commands.commands {
+		if allowed(cmd) {
+			names = append(names, cmd.name)
+		}
+	}
+	return "Commands: " + strings.Join(names, ", ") + ". Type help <command> for more."
+}
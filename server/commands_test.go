@@ -0,0 +1,146 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCommandParse(t *testing.T) {
+	r := newCommandRegistry(
+		&command{name: "east", aliases: []string{"e"}},
+		&command{name: "north", aliases: []string{"n"}},
+		&command{name: "northeast", aliases: []string{"ne"}},
+		&command{name: "northwest", aliases: []string{"nw"}},
+		&command{name: "emote", args: []argSpec{{name: "action", rest: true}}},
+		&command{name: "say", args: []argSpec{{name: "message", rest: true}}},
+		&command{name: "shout", args: []argSpec{{name: "message", rest: true}}},
+		&command{name: "tell", args: []argSpec{{name: "player"}, {name: "message", rest: true}}},
+		&command{name: "layout", args: []argSpec{{name: "name", optional: true}}},
+		&command{name: "rotatekeys", perm: permAdmin},
+	)
+	player := func(cmd *command) bool { return cmd.perm == permPlayer }
+
+	tests := []struct {
+		name string
+		line string
+
+		command string
+		args    []string
+		err     string
+	}{
+		{
+			name: "empty line",
+			line: "   ",
+		},
+		{
+			name:    "alias",
+			line:    "e",
+			command: "east",
+			args:    []string{},
+		},
+		{
+			name:    "unambiguous prefix",
+			line:    "sh  hello   there ",
+			command: "shout",
+			args:    []string{"hello   there"},
+		},
+		{
+			name: "ambiguous prefix",
+			line: "s hi",
+			err:  `"s" could be say or shout.`,
+		},
+		{
+			name:    "prefix of names starting with one of them",
+			line:    "nor",
+			command: "north",
+			args:    []string{},
+		},
+		{
+			name:    "prefix of the longer name",
+			line:    "northw",
+			command: "northwest",
+			args:    []string{},
+		},
+		{
+			name:    "quoted argument",
+			line:    `tell "Mike" don't panic`,
+			command: "tell",
+			args:    []string{"Mike", "don't panic"},
+		},
+		{
+			name:    "missing optional argument",
+			line:    "LAYOUT",
+			command: "layout",
+			args:    []string{""},
+		},
+		{
+			name: "missing argument",
+			line: "tell Mike",
+			err:  "Usage: tell <player> <message>",
+		},
+		{
+			name: "too many arguments",
+			line: "layout wide map",
+			err:  "Usage: layout [name]",
+		},
+		{
+			name: "quote not closed",
+			line: `layout "wide`,
+			err:  "A quote is not closed.",
+		},
+		{
+			name: "typo",
+			line: "emoet waves",
+			err:  `Unknown command "emoet". Did you mean emote?`,
+		},
+		{
+			name: "nothing close",
+			line: "xyzzy",
+			err:  `Unknown command "xyzzy". Type help for a list of commands.`,
+		},
+		{
+			name: "command not allowed",
+			line: "rotatekeys",
+			err:  `Unknown command "rotatekeys". Type help for a list of commands.`,
+		},
+	}
+
+	for _, test := range tests {
+		cmd, args, err := r.parse(test.line, player)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: expected error %q, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		name := ""
+		if cmd != nil {
+			name = cmd.name
+		}
+		if name != test.command || (cmd != nil && !reflect.DeepEqual(args, test.args)) {
+			t.Errorf("%s: expected %s %q, got %s %q", test.name, test.command, test.args, name, args)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"", "", 0},
+		{"say", "say", 0},
+		{"sya", "say", 2},
+		{"nort", "north", 1},
+		{"", "quit", 4},
+	}
+	for _, test := range tests {
+		if got := editDistance(test.a, test.b); got != test.expected {
+			t.Errorf("editDistance(%q, %q): expected %d, got %d", test.a, test.b, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// Config holds the server settings found in the [config] block of server.toml.
+type Config struct {
+	Host          string `toml:"host"`
+	Port          int    `toml:"port"`
+	DBPath        string `toml:"db_path"`
+	MaxPlayers    int    `toml:"max_players"`
+	StartArea     string `toml:"start_area"`
+	StartRoom     string `toml:"start_room"`
+	StartPosition string `toml:"start_position"`
+	LogLevel      string `toml:"log_level"`
+
+	// Authentication switches, only read from server.toml.
+	AllowPassword  bool   `toml:"allow_password"`
+	AllowAnonymous bool   `toml:"allow_anonymous"`
+	AnonymousUser  string `toml:"anonymous_user"`
+
+	// HostKeyFiles are private keys provided by the operator. They are
+	// used instead of the generated host key of the same type.
+	HostKeyFiles []string `toml:"host_key_files"`
+	// HostKeyGrace is how long rotated host keys are advertised to
+	// clients before they replace the current ones.
+	HostKeyGrace Duration `toml:"host_key_grace"`
+
+	// Admins are the nicknames allowed to run admin commands.
+	Admins []string `toml:"admins"`
+
+	// ShutdownCountdown is how long players are warned before the server
+	// stops. ShutdownTimeout bounds the time spent saving players and
+	// closing connections afterwards.
+	ShutdownCountdown Duration `toml:"shutdown_countdown"`
+	ShutdownTimeout   Duration `toml:"shutdown_timeout"`
+
+	// AutosaveInterval is how often players that changed are saved.
+	AutosaveInterval Duration `toml:"autosave_interval"`
+
+	// SessionPolicy decides what happens when a player logs in while
+	// already online: the new login takes over the session or is rejected.
+	SessionPolicy string `toml:"session_policy"`
+	// LinkdeadTimeout is how long players who lost their connection stay
+	// in the world, waiting for them to reconnect.
+	LinkdeadTimeout Duration `toml:"linkdead_timeout"`
+
+	// OutputQueue is how many writes can wait for a slow client. When the
+	// queue is full, or holds maxOutboxBytes, OutputOverflow either drops
+	// the queued screen updates or disconnects the client.
+	OutputQueue    int    `toml:"output_queue"`
+	OutputOverflow string `toml:"output_overflow"`
+
+	// MessageHistory is how many messages a player can scroll back to.
+	MessageHistory int `toml:"message_history"`
+
+	// TickInterval is how often the world moves on by itself. Timed events
+	// happen on the first tick after they are due.
+	TickInterval Duration `toml:"tick_interval"`
+	// RegenInterval is how often hurt players heal a hit point.
+	RegenInterval Duration `toml:"regen_interval"`
+	// DayLength is how long a day of the game lasts.
+	DayLength Duration `toml:"day_length"`
+	// CombatRound is how long a round of a fight lasts.
+	CombatRound Duration `toml:"combat_round"`
+	// NPCRespawn is how long defeated NPCs take to come back.
+	NPCRespawn Duration `toml:"npc_respawn"`
+	// NPCActionInterval is how often NPCs that are not fighting act or
+	// wander.
+	NPCActionInterval Duration `toml:"npc_action_interval"`
+	// AreaReset is how often the areas are put back the way they started.
+	AreaReset Duration `toml:"area_reset"`
+	// Narration is a file with the templates fights are told with. The
+	// built-in ones are used when it is empty.
+	Narration string `toml:"narration"`
+
+	// ResetDB wipes all stored players on startup. It can only be
+	// requested from the command line.
+	ResetDB bool `toml:"-"`
+}
+
+// Duration is a time.Duration read from strings such as "72h" in server.toml.
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
+}
+
+// configFile mirrors the layout of server.toml.
+type configFile struct {
+	Config Config `toml:"config"`
+}
+
+// DefaultConfig returns the settings used for anything that is not set
+// in server.toml, the environment or the command line.
+func DefaultConfig() Config {
+	return Config{
+		Port:          3030,
+		DBPath:        filepath.Join("data", "thyra.db"),
+		MaxPlayers:    100,
+		StartArea:     "City",
+		StartRoom:     "Inn",
+		StartPosition: "1",
+		LogLevel:      "debug",
+
+		AllowPassword:  true,
+		AllowAnonymous: false,
+		AnonymousUser:  "guest",
+
+		HostKeyGrace: Duration{7 * 24 * time.Hour},
+
+		ShutdownCountdown: Duration{10 * time.Second},
+		ShutdownTimeout:   Duration{10 * time.Second},
+
+		AutosaveInterval: Duration{time.Minute},
+
+		SessionPolicy:   sessionTakeover,
+		LinkdeadTimeout: Duration{2 * time.Minute},
+
+		OutputQueue:    256,
+		OutputOverflow: overflowDrop,
+		MessageHistory: 500,
+
+		TickInterval:  Duration{time.Second},
+		RegenInterval: Duration{10 * time.Second},
+		DayLength:     Duration{24 * time.Minute},
+		CombatRound:   Duration{3 * time.Second},
+		NPCRespawn:    Duration{time.Minute},
+
+		NPCActionInterval: Duration{20 * time.Second},
+		AreaReset:         Duration{15 * time.Minute},
+	}
+}
+
+// LoadConfig reads the [config] block of the given server.toml file.
+// Settings missing from the file keep their default value.
+func LoadConfig(path string) (Config, error) {
+	fileContent, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	file := configFile{Config: DefaultConfig()}
+	if _, err := toml.Decode(string(fileContent), &file); err != nil {
+		return Config{}, fmt.Errorf("%s could not be unmarshaled: %v", path, err)
+	}
+	return file.Config, nil
+}
+
+// configFromEnv collects any settings provided via THYRA_* environment variables.
+func configFromEnv() (Config, error) {
+	c := Config{
+		Host:          os.Getenv("THYRA_HOST"),
+		DBPath:        os.Getenv("THYRA_DB"),
+		StartArea:     os.Getenv("THYRA_START_AREA"),
+		StartRoom:     os.Getenv("THYRA_START_ROOM"),
+		StartPosition: os.Getenv("THYRA_START_POSITION"),
+		LogLevel:      os.Getenv("THYRA_LOG_LEVEL"),
+	}
+	if port := os.Getenv("THYRA_PORT"); port != "" {
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid THYRA_PORT %q: %v", port, err)
+		}
+		c.Port = p
+	}
+	if max := os.Getenv("THYRA_MAX_PLAYERS"); max != "" {
+		m, err := strconv.Atoi(max)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid THYRA_MAX_PLAYERS %q: %v", max, err)
+		}
+		c.MaxPlayers = m
+	}
+	return c, nil
+}
+
+// merge overrides c with every setting that is set in o.
+func (c *Config) merge(o Config) {
+	if o.Host != "" {
+		c.Host = o.Host
+	}
+	if o.Port != 0 {
+		c.Port = o.Port
+	}
+	if o.DBPath != "" {
+		c.DBPath = o.DBPath
+	}
+	if o.MaxPlayers != 0 {
+		c.MaxPlayers = o.MaxPlayers
+	}
+	if o.StartArea != "" {
+		c.StartArea = o.StartArea
+	}
+	if o.StartRoom != "" {
+		c.StartRoom = o.StartRoom
+	}
+	if o.StartPosition != "" {
+		c.StartPosition = o.StartPosition
+	}
+	if o.LogLevel != "" {
+		c.LogLevel = o.LogLevel
+	}
+	if o.ResetDB {
+		c.ResetDB = true
+	}
+}
+
+// validate checks that the merged configuration can be used to run a server.
+func (c Config) validate() error {
+	if c.Port <= 0 || c.Port > 65535 {
+		return fmt.Errorf("invalid port: %d", c.Port)
+	}
+	if c.MaxPlayers <= 0 || c.MaxPlayers > 65535 {
+		return fmt.Errorf("invalid max_players: %d", c.MaxPlayers)
+	}
+	if _, err := log.LvlFromString(c.LogLevel); err != nil {
+		return fmt.Errorf("invalid log_level: %v", err)
+	}
+	if c.AllowAnonymous && !isValidUsername(c.AnonymousUser) {
+		return fmt.Errorf("invalid anonymous_user: %q", c.AnonymousUser)
+	}
+	if c.HostKeyGrace.Duration < 0 {
+		return fmt.Errorf("invalid host_key_grace: %v", c.HostKeyGrace.Duration)
+	}
+	if c.ShutdownCountdown.Duration < 0 {
+		return fmt.Errorf("invalid shutdown_countdown: %v", c.ShutdownCountdown.Duration)
+	}
+	if c.ShutdownTimeout.Duration <= 0 {
+		return fmt.Errorf("invalid shutdown_timeout: %v", c.ShutdownTimeout.Duration)
+	}
+	if c.AutosaveInterval.Duration <= 0 {
+		return fmt.Errorf("invalid autosave_interval: %v", c.AutosaveInterval.Duration)
+	}
+	if c.SessionPolicy != sessionTakeover && c.SessionPolicy != sessionReject {
+		return fmt.Errorf("invalid session_policy: %q", c.SessionPolicy)
+	}
+	if c.LinkdeadTimeout.Duration < 0 {
+		return fmt.Errorf("invalid linkdead_timeout: %v", c.LinkdeadTimeout.Duration)
+	}
+	if c.OutputQueue <= 0 {
+		return fmt.Errorf("invalid output_queue: %d", c.OutputQueue)
+	}
+	if c.OutputOverflow != overflowDrop && c.OutputOverflow != overflowDisconnect {
+		return fmt.Errorf("invalid output_overflow: %q", c.OutputOverflow)
+	}
+	if c.MessageHistory <= 0 {
+		return fmt.Errorf("invalid message_history: %d", c.MessageHistory)
+	}
+	if c.TickInterval.Duration <= 0 {
+		return fmt.Errorf("invalid tick_interval: %v", c.TickInterval.Duration)
+	}
+	if c.RegenInterval.Duration < c.TickInterval.Duration {
+		return fmt.Errorf("invalid regen_interval: %v is shorter than the tick", c.RegenInterval.Duration)
+	}
+	if c.DayLength.Duration < 4*c.TickInterval.Duration {
+		return fmt.Errorf("invalid day_length: %v is shorter than four ticks", c.DayLength.Duration)
+	}
+	if c.CombatRound.Duration < c.TickInterval.Duration {
+		return fmt.Errorf("invalid combat_round: %v is shorter than the tick", c.CombatRound.Duration)
+	}
+	if c.NPCRespawn.Duration < 0 {
+		return fmt.Errorf("invalid npc_respawn: %v", c.NPCRespawn.Duration)
+	}
+	if c.NPCActionInterval.Duration < c.TickInterval.Duration {
+		return fmt.Errorf("invalid npc_action_interval: %v is shorter than the tick", c.NPCActionInterval.Duration)
+	}
+	if c.AreaReset.Duration < c.TickInterval.Duration {
+		return fmt.Errorf("invalid area_reset: %v is shorter than the tick", c.AreaReset.Duration)
+	}
+	return nil
+}
+
+// buildConfig layers the defaults, the given server.toml, the environment and
+// the command line overrides, in that order.
+func buildConfig(configPath string, overrides Config) (Config, error) {
+	cfg, err := LoadConfig(configPath)
+	switch {
+	case os.IsNotExist(err):
+		log.Warn(fmt.Sprintf("%s not found, using default configuration", configPath))
+		cfg = DefaultConfig()
+	case err != nil:
+		return Config{}, err
+	}
+
+	envCfg, err := configFromEnv()
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.merge(envCfg)
+	cfg.merge(overrides)
+
+	return cfg, cfg.validate()
+}
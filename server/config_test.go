@@ -0,0 +1,117 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBuildConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "thyra-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configPath := filepath.Join(dir, "server.toml")
+	content := `
+[config]
+host = "127.0.0.1"
+port = 4000
+max_players = 10
+start_room = "Cage"
+log_level = "info"
+allow_password = false
+host_key_grace = "72h"
+admins = ["Mike"]
+`
+	if err := ioutil.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+
+		env       map[string]string
+		overrides Config
+
+		expected Config
+		wantErr  bool
+	}{
+		{
+			name: "file values over defaults",
+
+			expected: func() Config {
+				c := DefaultConfig()
+				c.Host = "127.0.0.1"
+				c.Port = 4000
+				c.MaxPlayers = 10
+				c.StartRoom = "Cage"
+				c.LogLevel = "info"
+				c.AllowPassword = false
+				c.HostKeyGrace = Duration{72 * time.Hour}
+				c.Admins = []string{"Mike"}
+				return c
+			}(),
+		},
+		{
+			name: "env and flags over file values",
+
+			env:       map[string]string{"THYRA_PORT": "5000", "THYRA_MAX_PLAYERS": "20", "THYRA_LOG_LEVEL": "warn"},
+			overrides: Config{Port: 6000, DBPath: "/var/lib/thyra/shard.db"},
+
+			expected: func() Config {
+				c := DefaultConfig()
+				c.Host = "127.0.0.1"
+				c.Port = 6000
+				c.DBPath = "/var/lib/thyra/shard.db"
+				c.MaxPlayers = 20
+				c.StartRoom = "Cage"
+				c.LogLevel = "warn"
+				c.AllowPassword = false
+				c.HostKeyGrace = Duration{72 * time.Hour}
+				c.Admins = []string{"Mike"}
+				return c
+			}(),
+		},
+		{
+			name: "invalid env value",
+
+			env:     map[string]string{"THYRA_PORT": "many"},
+			wantErr: true,
+		},
+		{
+			name: "invalid log level",
+
+			overrides: Config{LogLevel: "loud"},
+			wantErr:   true,
+		},
+	}
+
+	for _, test := range tests {
+		for k, v := range test.env {
+			os.Setenv(k, v)
+		}
+		got, err := buildConfig(configPath, test.overrides)
+		for k := range test.env {
+			os.Unsetenv(k)
+		}
+
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: expected config: %+v\ngot: %+v", test.name, test.expected, got)
+		}
+	}
+}
@@ -1,20 +1,20 @@
 package server
 
 import (
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/x509"
-	"encoding/pem"
 	"fmt"
-	"strings"
+	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/boltdb/bolt"
-	"golang.org/x/crypto/ssh"
+	log "gopkg.in/inconshreveable/log15.v2"
 )
 
 var (
 	playerBucket = []byte("players")
 	configBucket = []byte("config")
+	// configSSHKey is the RSA host key of older servers. It is moved to
+	// the host keys bucket on startup.
 	configSSHKey = []byte("ssh-private-key")
 )
 
@@ -24,74 +24,33 @@ type database struct {
 	*bolt.DB
 }
 
+// newDatabase opens the database at loc, creating it if needed. If reset is
+// true, all stored players are deleted. Host keys are always kept.
 func newDatabase(loc string, reset bool) (*database, error) {
-	b, err := bolt.Open(loc, 0600, nil)
-	if err != nil {
+	if err := os.MkdirAll(filepath.Dir(loc), 0700); err != nil {
 		return nil, fmt.Errorf("Database error (%s)", err)
 	}
+	// Fail instead of blocking forever if another server holds the lock.
+	b, err := bolt.Open(loc, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("Database error (%s): %s", loc, err)
+	}
+	log.Info(fmt.Sprintf("Using database %s", loc))
 	db := &database{
 		DB: b,
 	}
 	if reset {
-		db.Update(func(tx *bolt.Tx) error {
-			return tx.DeleteBucket(playerBucket)
-		})
-	}
-	return db, nil
-}
-
-func (db *database) getPrivateKey(s *Server) error {
-	err := db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(configBucket)
-		if b == nil {
-			return nil
-		}
-		key := b.Get(configSSHKey)
-		if key != nil {
-			//only load RSA keys
-			if strings.Contains(string(key), "RSA PRIVATE KEY") {
-				if p, err := ssh.ParsePrivateKey(key); err == nil {
-					s.privateKey = p
-					return nil
-				}
+		log.Warn("Resetting all stored players")
+		err := db.Update(func(tx *bolt.Tx) error {
+			if err := tx.DeleteBucket(playerBucket); err != nil && err != bolt.ErrBucketNotFound {
+				return err
 			}
+			return nil
+		})
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("Database reset failed (%s)", err)
 		}
-		return nil
-	})
-	if err != nil {
-		return err
-	}
-	if s.privateKey != nil {
-		return nil
-	}
-	val, err := genPrivateKey()
-	if err != nil {
-		return err
-	}
-	if p, keyerr := ssh.ParsePrivateKey(val); err == nil {
-		s.privateKey = p
-	} else {
-		return keyerr
-	}
-	err = db.Update(func(tx *bolt.Tx) error {
-		if b, err := tx.CreateBucketIfNotExists(configBucket); err != nil {
-			return err
-		} else if err := b.Put(configSSHKey, val); err == nil {
-			return err
-		}
-		return nil
-	})
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func genPrivateKey() ([]byte, error) {
-	priv, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		return nil, err
 	}
-	key := x509.MarshalPKCS1PrivateKey(priv)
-	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: key}), nil
+	return db, nil
 }
@@ -1,6 +1,127 @@
 package server
 
+import (
+	"sync"
+	"time"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// EventType tells what an event is about and what its payload is.
+type EventType int
+
+const (
+	// EventCommand is a line typed at the prompt. Payload: CommandEvent.
+	EventCommand EventType = iota
+	// EventConnect is sent when a client logged in. No payload.
+	EventConnect
+	// EventDisconnect is sent when a client leaves the game or loses its
+	// connection. Payload: DisconnectEvent.
+	EventDisconnect
+	// EventResize is sent when the terminal of a client changed size.
+	// Payload: ResizeEvent.
+	EventResize
+	// EventScroll scrolls the message log of a client. Payload: ScrollEvent.
+	EventScroll
+	// EventTick is sent every tick_interval, without a client. Payload:
+	// TickEvent.
+	EventTick
+	// EventAdmin is an action of the server itself, without a client.
+	// Payload: AdminEvent.
+	EventAdmin
+	// EventTerminal is sent when a client told what terminal it has.
+	// Payload: TerminalEvent.
+	EventTerminal
+)
+
+var eventTypeNames = map[EventType]string{
+	EventCommand:    "command",
+	EventConnect:    "connect",
+	EventDisconnect: "disconnect",
+	EventResize:     "resize",
+	EventScroll:     "scroll",
+	EventTick:       "tick",
+	EventAdmin:      "admin",
+	EventTerminal:   "terminal",
+}
+
+func (t EventType) String() string {
+	return eventTypeNames[t]
+}
+
+// CommandEvent is the payload of EventCommand.
+type CommandEvent struct {
+	Line string
+}
+
+// DisconnectEvent is the payload of EventDisconnect. Linkdead is set when the
+// connection was lost rather than closed by the player, who can then come
+// back to the session.
+type DisconnectEvent struct {
+	Linkdead bool
+}
+
+// ResizeEvent is the payload of EventResize.
+type ResizeEvent struct {
+	Width, Height int
+}
+
+// ScrollEvent is the payload of EventScroll.
+type ScrollEvent struct {
+	Up bool
+}
+
+// TickEvent is the payload of EventTick.
+type TickEvent struct {
+	Time time.Time
+}
+
+// AdminEvent is the payload of EventAdmin.
+type AdminEvent struct {
+	// Broadcast is shown to every online player.
+	Broadcast string
+	// Save saves every online player right away. The error, if any, is the
+	// reply to the event.
+	Save bool
+}
+
+// TerminalEvent is the payload of EventTerminal: the TERM and COLORTERM of
+// the client, which decide the colors it gets.
+type TerminalEvent struct {
+	Term, ColorTerm string
+}
+
+// Event is something God has to handle. Players cannot make up events other
+// than commands, since everything they type is the payload of a command.
 type Event struct {
-	Client    *Client
-	EventType string
+	Type    EventType
+	Client  *Client
+	Payload interface{}
+	// Reply, when set, receives the outcome once God handled the event: nil,
+	// or the error shown to the client. It needs room for a value, as God
+	// does not wait for it to be read.
+	Reply chan error
+}
+
+// ticker sends an EventTick to God every tick_interval until stopCh is
+// closed.
+func (s *Server) ticker(stopCh <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	t := time.NewTicker(s.config.TickInterval.Duration)
+	defer t.Stop()
+	for {
+		select {
+		case <-stopCh:
+			log.Info("ticker is exiting.")
+			return
+		case now := <-t.C:
+			select {
+			case s.Events <- Event{Type: EventTick, Payload: TickEvent{Time: now}}:
+			case <-stopCh:
+				log.Info("ticker is exiting.")
+				return
+			}
+		}
+	}
 }
@@ -1,7 +1,6 @@
 package server
 
 import (
-	"bytes"
 	"fmt"
 	"strconv"
 	"sync"
@@ -23,78 +22,177 @@ func (s *Server) God(stopCh <-chan struct{}, wg *sync.WaitGroup) {
 			roomsMap[a.Name][room.Name] = s.CreateRoom(a.Name, room.Name)
 		}
 	}
+	s.godScheduleWorld(roomsMap)
 
-	msg := ""
+	// Players are saved in the background.
+	saverWg := &sync.WaitGroup{}
+	saverWg.Add(1)
+	go s.autosave.saver(stopCh, saverWg)
+	autosaveTicker := time.NewTicker(s.config.AutosaveInterval.Duration)
+	defer autosaveTicker.Stop()
 
 	for {
 		select {
 		case <-stopCh:
+			saverWg.Wait()
+			if err := s.autosave.saveAll(); err != nil {
+				log.Error(fmt.Sprintf("Cannot save players: %v", err))
+			}
 			log.Info("God is exiting.")
 			return
+		case <-autosaveTicker.C:
+			s.autosave.flush()
+		case res := <-s.autosave.results:
+			s.godSaveDone(roomsMap, res)
 		case ev := <-s.Events:
-			log.Debug(fmt.Sprintf("Player: %s, event type: %s", ev.Client.Name, ev.EventType))
-			c := ev.Client
-			online := s.OnlineClientsGetByRoom(c.Player.Area, c.Player.Room)
-			log.Debug(fmt.Sprintf("Clients in room %s: %s", c.Player.Room, Clients(online)))
+			err := s.godHandle(ev, roomsMap)
+			if ev.Reply != nil {
+				select {
+				case ev.Reply <- err:
+				default:
+					log.Warn(fmt.Sprintf("Dropped the reply to a %s event.", ev.Type))
+				}
+			}
+		}
+	}
+}
 
-			switch ev.EventType {
-			case "e", "east":
-				msg = doMove(c, online, roomsMap, 0)
+// godHandle handles an event. It returns the error shown to the client, if
+// any.
+func (s *Server) godHandle(ev Event, roomsMap map[string]map[string][][]area.Cube) error {
+	switch ev.Type {
+	case EventTick:
+		s.scheduler.run(ev.Payload.(TickEvent).Time)
+		return nil
+	case EventAdmin:
+		admin := ev.Payload.(AdminEvent)
+		if admin.Broadcast != "" {
+			s.godBroadcast(roomsMap, admin.Broadcast)
+		}
+		if admin.Save {
+			return s.saveOnlinePlayers()
+		}
+		return nil
+	}
 
-			case "w", "west":
-				msg = doMove(c, online, roomsMap, 1)
+	c := ev.Client
+	log.Debug(fmt.Sprintf("Player: %s, event: %s %+v", c.Name, ev.Type, ev.Payload))
+	if ev.Type == EventConnect {
+		s.godConnect(c, roomsMap)
+		return nil
+	}
+	// Ignore what is left of sessions that were taken over.
+	if !s.isOnline(c) {
+		return nil
+	}
 
-			case "n", "north":
-				msg = doMove(c, online, roomsMap, 2)
+	switch ev.Type {
+	case EventDisconnect:
+		if ev.Payload.(DisconnectEvent).Linkdead {
+			s.godLinkdead(c, roomsMap)
+		} else {
+			s.godDisconnect(c, roomsMap)
+		}
+	case EventResize:
+		resize := ev.Payload.(ResizeEvent)
+		c.w, c.h = resize.Width, resize.Height
+		c.ready = fits(c.w, c.h)
+		s.godPrintRoom([]Client{*c}, roomsMap)
+	case EventTerminal:
+		terminal := ev.Payload.(TerminalEvent)
+		c.profile = profileFromTerm(terminal.Term, terminal.ColorTerm)
+		// What was sent is in the colors of the old profile.
+		c.screen.invalidate()
+		s.godPrintRoom([]Client{*c}, roomsMap)
+	case EventScroll:
+		c.messages.page(ev.Payload.(ScrollEvent).Up)
+		s.godPrintRoom([]Client{*c}, roomsMap)
+	case EventCommand:
+		return s.godCommand(c, roomsMap, ev.Payload.(CommandEvent).Line)
+	}
+	return nil
+}
 
-			case "s", "south":
-				msg = doMove(c, online, roomsMap, 3)
+// godMove moves the client's player in the given direction and shows the
+// rooms it left and entered.
+func (s *Server) godMove(c *Client, roomsMap map[string]map[string][][]area.Cube, direction area.Direction) {
+	if s.world.fights[c.Player.Nickname] != nil {
+		s.godReply(c, roomsMap, "You are fighting!")
+		return
+	}
+	online := s.OnlineClientsGetByRoom(c.Player.Area, c.Player.Room)
+	log.Debug(fmt.Sprintf("Clients in room %s: %s", c.Player.Room, Clients(online)))
 
-			case "quit":
-				c.conn.Write(ansi.EraseScreen)
-				c.conn.Close()
-				s.savePlayer(*c.Player)
-				s.clientLoggedOut(c.Player.Nickname)
-			}
+	prevArea, prevRoom, prevPos := c.Player.Area, c.Player.Room, c.Player.Position
+	msg := doMove(c, online, s.world, roomsMap, direction)
+	if c.Player.Area != prevArea || c.Player.Room != prevRoom || c.Player.Position != prevPos {
+		s.autosave.markDirty(c.Player)
+	}
 
-			log.Info(fmt.Sprintf("msg: %s, player: %#v", msg, c.Player))
+	log.Info(fmt.Sprintf("msg: %s, player: %#v", msg, c.Player))
 
-			onlineCurrentRoom := s.OnlineClientsGetByRoom(c.Player.Area, c.Player.Room)
+	onlineCurrentRoom := s.OnlineClientsGetByRoom(c.Player.Area, c.Player.Room)
 
-			var globalMsg string
-			if msg == "door" {
-				globalMsg = fmt.Sprintf("%s enters the room.", c.Player.Nickname)
+	switch msg {
+	case "":
+	case "door":
+		onlinePreviousRoom := s.OnlineClientsGetByRoom(c.Player.PreviousArea, c.Player.PreviousRoom)
+		log.Info(fmt.Sprintf("Online clients in previous room (%s/%s): %s", c.Player.PreviousArea, c.Player.PreviousRoom, Clients(onlinePreviousRoom)))
+		if onlinePreviousRoom != nil {
+			godMessage(onlinePreviousRoom, msgRoom, fmt.Sprintf("%s left the room.", c.Player.Nickname))
+			s.godPrintRoom(onlinePreviousRoom, roomsMap)
+		}
+		godMessage(othersThan(onlineCurrentRoom, c), msgRoom, fmt.Sprintf("%s enters the room.", c.Player.Nickname))
+		godMessage([]Client{*c}, msgRoom, fmt.Sprintf("You enter %s.", c.Player.Room))
+	default:
+		// Why the player could not move.
+		godMessage([]Client{*c}, msgSystem, msg)
+	}
 
-				onlinePreviousRoom := s.OnlineClientsGetByRoom(c.Player.PreviousArea, c.Player.PreviousRoom)
-				log.Info(fmt.Sprintf("Online clients in previous room (%s/%s): %s", c.Player.PreviousArea, c.Player.PreviousRoom, Clients(onlinePreviousRoom)))
-				if onlinePreviousRoom != nil {
-					s.godPrintRoom(onlinePreviousRoom, roomsMap, "", fmt.Sprintf("%s left the room.", c.Player.Nickname))
-				}
-				// TODO: Do writes on the connection here.
-			}
+	log.Info(fmt.Sprintf("Online clients in room (%s/%s) for player %s: %s", c.Player.Area, c.Player.Room, c.Player.Nickname, Clients(onlineCurrentRoom)))
+	s.godPrintRoom(onlineCurrentRoom, roomsMap)
+}
 
-			if ev.EventType != "quit" {
-				// TODO: Sort out msg
-				log.Info(fmt.Sprintf("Online clients in room (%s/%s) for player %s: %s", c.Player.Area, c.Player.Room, c.Player.Nickname, Clients(onlineCurrentRoom)))
-				s.godPrintRoom(onlineCurrentRoom, roomsMap, "", globalMsg)
-				// TODO: Do writes on the connection here.
-			}
+// godMessage adds text to the message logs of the clients. Each line is a
+// message of its own.
+func godMessage(clients []Client, category msgCategory, text string) {
+	for _, c := range clients {
+		if c.messages != nil {
+			c.messages.add(category, text)
 		}
 	}
 }
 
+// othersThan returns the clients except c.
+func othersThan(clients []Client, c *Client) []Client {
+	var others []Client
+	for _, other := range clients {
+		if other.Name != c.Name {
+			others = append(others, other)
+		}
+	}
+	return others
+}
+
 // godPrintRoom updates the map, intros, and exits for all the provided clients in a room.
-// msg is a private message for a player and globalMsg is a global message in the room.
-func (s *Server) godPrintRoom(clients []Client, roomsMap map[string]map[string][][]area.Cube, msg, globalMsg string) /* Screen */ {
+// Messages are added to the message logs with godMessage beforehand, and
+// shown with the rest of the screen.
+func (s *Server) godPrintRoom(clients []Client, roomsMap map[string]map[string][][]area.Cube) {
+	if len(clients) == 0 {
+		return
+	}
 	now := time.Now()
 	log.Debug(fmt.Sprintf("godPrintRoom start: %v", now))
 
 	positionToCurrent := map[string]bool{}
 	mapArray := roomsMap[clients[0].Player.Area][clients[0].Player.Room]
 
-	for i := range clients {
-		c := clients[i]
-		positionToCurrent[c.Player.Position] = false
+	// Everyone in the room is on the map, not only the clients redrawn.
+	for _, other := range s.OnlineClientsGetByRoom(clients[0].Player.Area, clients[0].Player.Room) {
+		positionToCurrent[other.Player.Position] = false
+	}
+	for _, n := range s.world.npcsIn(clients[0].Player.Area, clients[0].Player.Room) {
+		positionToCurrent[n.Position] = false
 	}
 
 	for i := range clients {
@@ -102,14 +200,24 @@ func (s *Server) godPrintRoom(clients []Client, roomsMap map[string]map[string][
 		p := c.Player
 		log.Debug(fmt.Sprintf("Player: %s, Area: %s, Room: %s, CubeID: %s", c.Player.Nickname, c.Player.Area, c.Player.Room, c.Player.Position))
 
+		// Linkdead players are still on the map but have nowhere to draw,
+		// and terminals too small are told to grow.
+		if c.linkdead || !c.ready {
+			continue
+		}
+
 		posToCurr := copyMapWithNewPos(positionToCurrent, c.Player.Position)
 
-		// Re-create the Screen. Instead of clear
-		c.screen = NewScreen(c.w, c.h)
+		// Start a new frame. What the client was last sent is kept, unless
+		// the terminal was resized or frames were dropped on the way.
+		c.screen.resize(c.w, c.h)
+		c.screen.clear()
+		if c.out.takeStale() {
+			c.screen.invalidate()
+		}
 
 		// Create map
-		bufMap := area.PlayerCentricMap(p, posToCurr, mapArray)
-		c.screen.updateScreenRunes("map", bufMap)
+		c.screen.setMap(area.PlayerCentricMap(p, posToCurr, mapArray))
 
 		// Create Available movement
 		bufExits := area.PrintExits(area.FindExits(mapArray, c.Player.Area, c.Player.Room, c.Player.Position))
@@ -119,19 +227,20 @@ func (s *Server) godPrintRoom(clients []Client, roomsMap map[string]map[string][
 		buffIntro := area.PrintIntro(s.Areas[c.Player.Area].Rooms[c.Player.Room])
 		c.screen.updateScreenRunes("intro", buffIntro)
 
-		// TODO : Now messages are global. Separate private messages.
-		// Create Messages
-		c.screen.updateScreenRunes("message", *bytes.NewBufferString(msg))
+		c.screen.setSheet(p)
 
-		// TODO: Move writes out of here.
-		// Draw screen with Frame
+		// Draw screen with Frame and send what changed, then return the
+		// cursor to the prompt bar and show it again. God only queues the
+		// frame. Should older frames be dropped, the whole screen is sent.
 		drawScreenWithFrame(c)
-
-		// Return cursor to prompt bar
-		c.writeGoto(c.h-1, c.promptBar.position+1)
-
-		// Show cursor again
-		c.conn.Write(ansi.CursorShow)
+		changes := c.screen.render(c.profile)
+		if len(changes) == 0 {
+			continue
+		}
+		screen, profile, prompt := c.screen, c.profile, ansi.Goto(uint16(c.h-1), uint16(c.promptBar.position+1))
+		c.out.writeFrame(frame(changes, prompt), func() []byte {
+			return frame(screen.full(profile), prompt)
+		})
 	}
 
 	reallyNow := time.Now()
@@ -139,6 +248,59 @@ func (s *Server) godPrintRoom(clients []Client, roomsMap map[string]map[string][
 	log.Debug(fmt.Sprintf("Printed after %f ms", reallyNow.Sub(now).Seconds()*1000))
 }
 
+// godDisconnect is the only way a client leaves the game, whether it quit,
+// lost its connection or the server is shutting down. The client is removed
+// from the online clients, its ID goes back to the pool, the player is saved,
+// its goroutines are stopped and the room is told that the player left.
+func (s *Server) godDisconnect(c *Client, roomsMap map[string]map[string][][]area.Cube) {
+	if !s.clientLoggedOut(c) {
+		// Already disconnected.
+		return
+	}
+	log.Info(fmt.Sprintf("[%s] disconnected.", c.Name))
+
+	s.idPool <- c.id
+	s.godLeaveFight(c.Player.Nickname, roomsMap)
+	s.autosave.markDirty(c.Player)
+	s.autosave.flush(c.Player.Nickname)
+	c.stop()
+
+	room := s.OnlineClientsGetByRoom(c.Player.Area, c.Player.Room)
+	godMessage(room, msgRoom, fmt.Sprintf("%s left the game.", c.Player.Nickname))
+	s.godPrintRoom(room, roomsMap)
+}
+
+// godBroadcast shows msg to every online player.
+func (s *Server) godBroadcast(roomsMap map[string]map[string][][]area.Cube, msg string) {
+	clients := s.OnlineClients()
+	godMessage(clients, msgSystem, msg)
+	s.godPrintAll(clients, roomsMap)
+}
+
+// godPrintAll is godPrintRoom for clients that may be in different rooms.
+func (s *Server) godPrintAll(clients []Client, roomsMap map[string]map[string][][]area.Cube) {
+	rooms := make(map[string][]Client)
+	for _, c := range clients {
+		if !c.ready {
+			continue
+		}
+		key := c.Player.Area + "/" + c.Player.Room
+		rooms[key] = append(rooms[key], c)
+	}
+	for _, room := range rooms {
+		s.godPrintRoom(room, roomsMap)
+	}
+}
+
+// frame wraps screen output so that the cursor is hidden while drawing and
+// returns to the prompt bar.
+func frame(body, prompt []byte) []byte {
+	out := append([]byte{}, ansi.CursorHide...)
+	out = append(out, body...)
+	out = append(out, prompt...)
+	return append(out, ansi.CursorShow...)
+}
+
 func copyMapWithNewPos(m map[string]bool, currentPos string) map[string]bool {
 	copied := map[string]bool{}
 	for k, v := range m {
@@ -150,23 +312,24 @@ func copyMapWithNewPos(m map[string]bool, currentPos string) map[string]bool {
 	return copied
 }
 
-// Initiate the movement to the desired direction. Returns
-func doMove(c *Client, online []Client, roomsMap map[string]map[string][][]area.Cube, direction int) string {
+// Initiate the movement to the desired direction. Returns "door" when the
+// player changed rooms, or why the player could not move.
+func doMove(c *Client, online []Client, w *world, roomsMap map[string]map[string][][]area.Cube, direction area.Direction) string {
 	mapArray := roomsMap[c.Player.Area][c.Player.Room]
-	posArray := area.FindExits(mapArray, c.Player.Area, c.Player.Room, c.Player.Position)
-	newPosType := posArray[direction][3]
-	newArea := posArray[direction][0]
-	newRoom := posArray[direction][2]
-	newPos := posArray[direction][1]
+	to, ok := area.FindExits(mapArray, c.Player.Area, c.Player.Room, c.Player.Position)[direction]
+	if !ok {
+		return "You can't go that way\n"
+	}
+	newArea, newRoom, newPos := to.Area, to.Room, to.CubeID
 
-	log.Info(fmt.Sprintf("Player: %s, pos: %s->%s, pos type: %s, area: %s->%s, room: %s->%s",
-		c.Player.Nickname, c.Player.Position, newPos, newPosType, c.Player.Area, newArea, c.Player.Room, newRoom))
+	log.Info(fmt.Sprintf("Player: %s, pos: %s->%s, door: %t, area: %s->%s, room: %s->%s",
+		c.Player.Nickname, c.Player.Position, newPos, to.Door, c.Player.Area, newArea, c.Player.Room, newRoom))
 
 	// Check if the destination cube is available.
-	isAvailable, info := isCubeAvailable(*c, online, newArea, newRoom, newPos)
+	isAvailable, info := isCubeAvailable(*c, online, w, newArea, newRoom, newPos)
 
 	msg := ""
-	if newPosType == "door" {
+	if to.Door {
 		msg = "door"
 	}
 	if isAvailable {
@@ -184,10 +347,13 @@ func doMove(c *Client, online []Client, roomsMap map[string]map[string][][]area.
 // TODO: Switch cube to a Cube struct.
 // Check if the given cube is available,
 // otherwise includes info about what or who is occupying it.
-func isCubeAvailable(client Client, online []Client, area string, room string, cube string) (bool, string) {
+func isCubeAvailable(client Client, online []Client, w *world, area string, room string, cube string) (bool, string) {
 	if cubeNum, _ := strconv.Atoi(cube); cubeNum <= 0 {
 		return false, "You can't go that way\n"
 	}
+	if n := w.npcAt(area, room, cube); n != nil {
+		return false, n.Name + " is blocking the way\n"
+	}
 
 	for i := range online {
 		c := online[i]
@@ -254,58 +420,27 @@ func drawScreen(c Client) {
 }
 */
 
-// TODO: add messages,exits and scoresheet
-// Draw screen canvas inside dynamic frames.
+// drawScreenWithFrame places the canvases in the panels of the player's
+// layout, inside a frame.
 func drawScreenWithFrame(c Client) {
+	pl := layoutFor(c.Player.Layout).place(c.w, c.h)
+	c.screen.drawFrame(pl)
 
-	u := make([]byte, 0)
-	c.conn.Write(ansi.CursorHide)
-	c.conn.Write(ansi.Goto(0, 0))
-
-	// Create Frame
-	for y := 0; y < len(c.screen.screenRunes)-1; y++ {
-		for x := 0; x < len(c.screen.screenRunes[y]); x++ {
-			if x == 0 || x == c.w-1 {
-				c.screen.screenRunes[y][x] = '|'
-			} else if x == int(float64(c.w)/7.5) && y > 0 && y != c.h-4 {
-				c.screen.screenRunes[y][x] = '|'
-			} else if y == c.h-4 || y == 0 {
-				c.screen.screenRunes[y][x] = '-'
-			} else if y == int(float64(c.h)/3) && x > int(float64(c.w)/7.5) {
-				c.screen.screenRunes[y][x] = '-'
-			} else if x == int(float64(c.w)/1.4) && y > int(float64(c.h)/3) {
-				c.screen.screenRunes[y][x] = '|'
-			}
-		}
+	if r, ok := pl.panels[panelIntro]; ok {
+		c.screen.drawPanel(r, c.screen.introCanvas, nil, alignTop)
 	}
-
-	// Add mapCanvas to screenRunes
-	for h := 0; h < len(c.screen.mapCanvas); h++ {
-		for w := 0; w < len(c.screen.mapCanvas[h]); w++ {
-			if int(float64(c.h)/2.5)+h < c.h-4 && int(float64(c.w)/1.3)+w < c.w-2 {
-				c.screen.screenRunes[int(float64(c.h)/2.5)+h][int(float64(c.w)/1.3)+w] = c.screen.mapCanvas[h][w]
-
-			}
-		}
+	if r, ok := pl.panels[panelSheet]; ok {
+		c.screen.drawPanel(r, c.screen.sheetCanvas, nil, alignTop)
 	}
-	// Clear mapCanvas
-	c.screen.mapCanvas = [][]rune{}
-
-	// Add Intro to screenRunes
-	for h := 0; h < len(c.screen.introCanvas); h++ {
-		for w := 0; w < len(c.screen.introCanvas[h]); w++ {
-			if int(float64(c.h)/100+10)+h > c.h-c.h+2 && int(float64(c.w)/6.3)+w < c.w-2 && h < int(float64(c.h)/3)-2 {
-				c.screen.screenRunes[int(float64(c.h)/100+2)+h][int(float64(c.w)/6.3)+w] = c.screen.introCanvas[h][w]
-			}
-		}
+	if r, ok := pl.panels[panelLog]; ok && c.messages != nil {
+		inner := r.inner()
+		runes, attrs := c.messages.view(inner.w, inner.h)
+		c.screen.drawPanel(r, runes, attrs, alignTop)
 	}
-
-	// Write all the screen data.
-	for x := 0; x < len(c.screen.screenRunes)-1; x++ {
-		for y := 0; y < len(c.screen.screenRunes[x]); y++ {
-			u = append(u, []byte(string(c.screen.screenRunes[x][y]))...)
-		}
-		u = append(u, []byte(string("\r\n"))...)
+	if r, ok := pl.panels[panelMap]; ok {
+		c.screen.drawPanel(r, c.screen.mapCanvas, c.screen.mapAttrs, alignCenter)
+	}
+	if r, ok := pl.panels[panelExits]; ok {
+		c.screen.drawPanel(r, [][]rune{c.screen.exitCanvas}, nil, alignCenter)
 	}
-	c.conn.Write(u)
 }
@@ -1,41 +1,473 @@
 package server
 
 import (
+	"bytes"
+	"net"
+	"os"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/gothyra/thyra/area"
+
+	"golang.org/x/crypto/ssh"
 )
 
 func TestGodPrintRoom(t *testing.T) {
 	tests := []struct {
 		name string
 
-		clients   []Client
-		roomsMap  map[string]map[string][][]area.Cube
-		msg       string
-		globalMsg string
-
-		expectedScreen Screen
+		clients  []Client
+		roomsMap map[string]map[string][][]area.Cube
 	}{
 		// TODO: Add test cases.
 		{
 			name: "empty room",
 
-			clients:   []Client{},
-			roomsMap:  make(map[string]map[string][][]area.Cube),
-			msg:       "",
-			globalMsg: "",
-
-			expectedScreen: Screen{},
+			clients:  []Client{},
+			roomsMap: make(map[string]map[string][][]area.Cube),
 		},
 	}
 
 	for _, test := range tests {
 		s := Server{Areas: make(map[string]area.Area)}
-		got := s.godPrintRoom(test.clients, test.roomsMap, test.msg, test.globalMsg)
-		if !reflect.DeepEqual(got, test.expectedScreen) {
-			t.Errorf("expected screen: %v\ngot: %v", test.expectedScreen, got)
+		// Must not panic.
+		s.godPrintRoom(test.clients, test.roomsMap)
+	}
+}
+
+func TestGodHandle(t *testing.T) {
+	player := &area.Player{Nickname: "Bob", Area: "City", Room: "Inn", Position: "1"}
+	online := &Client{
+		Name:      "Bob",
+		Player:    player,
+		messages:  newMessageLog(10),
+		screen:    &Screen{},
+		out:       newOutbox(&bytes.Buffer{}, 100, overflowDrop),
+		promptBar: &PromptBar{},
+	}
+	gone := &Client{Name: "Bob", Player: player, messages: newMessageLog(10)}
+
+	tests := []struct {
+		name  string
+		event Event
+
+		err string
+		// lastMessage is what the client's message log ends with.
+		lastMessage string
+	}{
+		{
+			name:        "command",
+			event:       Event{Type: EventCommand, Client: online, Payload: CommandEvent{Line: "layout"}},
+			lastMessage: "Layout: classic. Available layouts: classic, compact, wide.",
+		},
+		{
+			name:        "unknown command",
+			event:       Event{Type: EventCommand, Client: online, Payload: CommandEvent{Line: "nrth"}},
+			err:         `Unknown command "nrth". Did you mean north?`,
+			lastMessage: `Unknown command "nrth". Did you mean north?`,
+		},
+		{
+			name:        "empty line",
+			event:       Event{Type: EventCommand, Client: online, Payload: CommandEvent{Line: ""}},
+			lastMessage: `Unknown command "nrth". Did you mean north?`,
+		},
+		{
+			name:        "client that is not online",
+			event:       Event{Type: EventCommand, Client: gone, Payload: CommandEvent{Line: "nrth"}},
+			lastMessage: `Unknown command "nrth". Did you mean north?`,
+		},
+		{
+			name:        "resize",
+			event:       Event{Type: EventResize, Client: online, Payload: ResizeEvent{Width: 80, Height: 24}},
+			lastMessage: `Unknown command "nrth". Did you mean north?`,
+		},
+		{
+			name:        "terminal",
+			event:       Event{Type: EventTerminal, Client: online, Payload: TerminalEvent{Term: "xterm-256color", ColorTerm: "truecolor"}},
+			lastMessage: `Unknown command "nrth". Did you mean north?`,
+		},
+		{
+			name:        "tick",
+			event:       Event{Type: EventTick, Payload: TickEvent{Time: time.Now()}},
+			lastMessage: `Unknown command "nrth". Did you mean north?`,
+		},
+		{
+			name:        "broadcast",
+			event:       Event{Type: EventAdmin, Payload: AdminEvent{Broadcast: "Hello everyone."}},
+			lastMessage: "Hello everyone.",
+		},
+	}
+
+	s := &Server{
+		config:        DefaultConfig(),
+		onlineClients: map[string]*Client{"Bob": online},
+		commands:      newCommandRegistry(gameCommands...),
+		scheduler:     newScheduler(time.Now()),
+		world:         newWorld(nil, 1),
+		Areas:         make(map[string]area.Area),
+	}
+	roomsMap := make(map[string]map[string][][]area.Cube)
+	for _, test := range tests {
+		err := s.godHandle(test.event, roomsMap)
+		if (err == nil && test.err != "") || (err != nil && err.Error() != test.err) {
+			t.Errorf("%s: expected error %q, got %v", test.name, test.err, err)
+		}
+		if len(gone.messages.entries) > 0 {
+			t.Errorf("%s: a client that is not online got a message", test.name)
+		}
+
+		last := ""
+		if entries := online.messages.entries; len(entries) > 0 {
+			last = entries[len(entries)-1].text
+		}
+		if last != test.lastMessage {
+			t.Errorf("%s: expected the last message to be %q, got %q", test.name, test.lastMessage, last)
+		}
+	}
+	if online.w != 80 || online.h != 24 || !online.ready {
+		t.Errorf("expected the resize to set the size to 80x24 and make the client ready, got %dx%d, ready %t", online.w, online.h, online.ready)
+	}
+	if online.profile != profileTrueColor {
+		t.Errorf("expected the terminal to set a truecolor profile, got %d", online.profile)
+	}
+}
+
+func TestGodReply(t *testing.T) {
+	store, dir := newTestStore(t)
+	defer os.RemoveAll(dir)
+	defer store.db.Close()
+
+	player := &area.Player{Nickname: "Bob", Area: "City", Room: "Inn", Position: "1"}
+	online := &Client{Name: "Bob", Player: player, messages: newMessageLog(10)}
+	s := &Server{
+		config:        DefaultConfig(),
+		Events:        make(chan Event),
+		onlineClients: map[string]*Client{"Bob": online},
+		commands:      newCommandRegistry(gameCommands...),
+		scheduler:     newScheduler(time.Now()),
+		world:         newWorld(nil, 1),
+		autosave:      newAutosave(store),
+		store:         store,
+		Areas:         make(map[string]area.Area),
+	}
+	stopCh := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go s.God(stopCh, wg)
+	defer wg.Wait()
+	defer close(stopCh)
+
+	tests := []struct {
+		name  string
+		event Event
+
+		err string
+	}{
+		{
+			name:  "command",
+			event: Event{Type: EventCommand, Client: online, Payload: CommandEvent{Line: "layout"}},
+		},
+		{
+			name:  "unknown command",
+			event: Event{Type: EventCommand, Client: online, Payload: CommandEvent{Line: "nrth"}},
+			err:   `Unknown command "nrth". Did you mean north?`,
+		},
+		{
+			name:  "save",
+			event: Event{Type: EventAdmin, Payload: AdminEvent{Save: true}},
+		},
+	}
+	for _, test := range tests {
+		reply := make(chan error, 1)
+		test.event.Reply = reply
+		s.Events <- test.event
+		select {
+		case err := <-reply:
+			if (err == nil && test.err != "") || (err != nil && err.Error() != test.err) {
+				t.Errorf("%s: expected the reply %q, got %v", test.name, test.err, err)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%s: no reply from God", test.name)
+		}
+	}
+	if _, err := store.Load("Bob"); err != nil {
+		t.Errorf("expected Bob to be saved, got %v", err)
+	}
+}
+
+// testSSHConn is an SSH connection that sends nothing anywhere.
+type testSSHConn struct {
+	ssh.Conn
+}
+
+func (testSSHConn) Close() error         { return nil }
+func (testSSHConn) RemoteAddr() net.Addr { return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)} }
+
+// newSessionServer returns a server with a single room, the Inn of the City,
+// and room for three clients in its ID pool.
+func newSessionServer(policy string, linkdead time.Duration) *Server {
+	config := DefaultConfig()
+	config.SessionPolicy = policy
+	config.LinkdeadTimeout = Duration{linkdead}
+	return &Server{
+		config:        config,
+		idPool:        make(chan ID, 3),
+		onlineClients: make(map[string]*Client),
+		commands:      newCommandRegistry(gameCommands...),
+		scheduler:     newScheduler(time.Now()),
+		world:         newWorld(nil, 1),
+		autosave:      newAutosave(nil),
+		Areas:         make(map[string]area.Area),
+	}
+}
+
+// newSessionClient returns a client of the player, connected but not yet
+// in the game.
+func newSessionClient(id ID, player *area.Player) *Client {
+	c := NewClient(id, player.Nickname, player.Nickname, "", testSSHConn{}, newOutbox(&bytes.Buffer{}, 100, overflowDrop), player)
+	c.messages = newMessageLog(10)
+	return c
+}
+
+// stopped reports whether the goroutines of c were told to exit.
+func stopped(c *Client) bool {
+	select {
+	case <-c.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// returnedIDs empties the ID pool of s.
+func returnedIDs(s *Server) []ID {
+	var ids []ID
+	for {
+		select {
+		case id := <-s.idPool:
+			ids = append(ids, id)
+		default:
+			return ids
+		}
+	}
+}
+
+func TestGodConnect(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   string
+		existing bool // Bob is already online
+		linkdead bool // and lost his connection
+
+		online   ID   // the client left playing Bob
+		returned []ID // the IDs given back to the pool
+		stopped  []ID // the clients told to exit
+		// told is what the client of the given ID is told.
+		told    map[ID]string
+		carried bool   // the new client continues the existing session
+		watched string // the last message of Mike, in the same room
+	}{
+		{
+			name:   "first login",
+			policy: sessionTakeover,
+			online: 2,
+		},
+		{
+			name:     "takeover",
+			policy:   sessionTakeover,
+			existing: true,
+			online:   2,
+			returned: []ID{1},
+			stopped:  []ID{1},
+			told:     map[ID]string{1: "Your session was taken over by a new login."},
+			carried:  true,
+		},
+		{
+			name:     "reject",
+			policy:   sessionReject,
+			existing: true,
+			online:   1,
+			returned: []ID{2},
+			stopped:  []ID{2},
+			told:     map[ID]string{2: "Bob is already playing from another connection."},
+		},
+		{
+			name:     "linkdead reattached despite reject",
+			policy:   sessionReject,
+			existing: true,
+			linkdead: true,
+			online:   2,
+			returned: []ID{1},
+			carried:  true,
+			watched:  "Bob has reconnected.",
+		},
+	}
+
+	for _, test := range tests {
+		s := newSessionServer(test.policy, time.Minute)
+		roomsMap := make(map[string]map[string][][]area.Cube)
+		mike := newSessionClient(3, &area.Player{Nickname: "Mike", Area: "City", Room: "Inn", Position: "2"})
+		s.onlineClients["Mike"] = mike
+
+		clients := make(map[ID]*Client)
+		if test.existing {
+			old := newSessionClient(1, &area.Player{Nickname: "Bob", Area: "City", Room: "Inn", Position: "1"})
+			old.messages.add(msgSay, "Mike says: hi")
+			old.replyTo = "Mike"
+			old.linkdead = test.linkdead
+			s.onlineClients["Bob"] = old
+			clients[1] = old
+		}
+		c := newSessionClient(2, &area.Player{Nickname: "Bob", Area: "City", Room: "Inn", Position: "1"})
+		clients[2] = c
+		s.godConnect(c, roomsMap)
+
+		if got := s.onlineClients["Bob"]; got != clients[test.online] {
+			t.Errorf("%s: expected client %d to play Bob, got %v", test.name, test.online, got)
+		}
+		if got := returnedIDs(s); !reflect.DeepEqual(got, test.returned) {
+			t.Errorf("%s: expected the IDs %v back in the pool, got %v", test.name, test.returned, got)
+		}
+		for id, client := range clients {
+			expected := false
+			for _, stoppedID := range test.stopped {
+				expected = expected || stoppedID == id
+			}
+			if stopped(client) != expected {
+				t.Errorf("%s: expected client %d stopped %t, got %t", test.name, id, expected, stopped(client))
+			}
+			if told := test.told[id]; told != "" && !strings.Contains(string(client.out.take()), told) {
+				t.Errorf("%s: expected client %d to be told %q", test.name, id, told)
+			}
+		}
+		if test.carried {
+			old := clients[1]
+			if c.Player != old.Player || c.messages != old.messages || c.replyTo != "Mike" {
+				t.Errorf("%s: expected the player, messages and reply to carry over to the new client", test.name)
+			}
+		}
+		if got := lastMessage(mike); got != test.watched {
+			t.Errorf("%s: expected Mike's last message to be %q, got %q", test.name, test.watched, got)
+		}
+	}
+}
+
+func TestGodLinkdead(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		// elapsed is how long Bob has been linkdead when the world reaps.
+		elapsed time.Duration
+
+		online   bool
+		returned []ID
+		watched  string
+	}{
+		{
+			name:     "linkdead disabled",
+			returned: []ID{1},
+			watched:  "Bob left the game.",
+		},
+		{
+			name:    "waiting for a reconnect",
+			timeout: time.Minute,
+			elapsed: time.Minute - time.Second,
+			online:  true,
+			watched: "Bob lost the connection.",
+		},
+		{
+			name:     "reaped",
+			timeout:  time.Minute,
+			elapsed:  time.Minute,
+			returned: []ID{1},
+			watched:  "Bob left the game.",
+		},
+	}
+
+	for _, test := range tests {
+		s := newSessionServer(sessionTakeover, test.timeout)
+		roomsMap := make(map[string]map[string][][]area.Cube)
+		bob := newSessionClient(1, &area.Player{Nickname: "Bob", Area: "City", Room: "Inn", Position: "1"})
+		mike := newSessionClient(2, &area.Player{Nickname: "Mike", Area: "City", Room: "Inn", Position: "2"})
+		s.onlineClients["Bob"], s.onlineClients["Mike"] = bob, mike
+
+		s.godLinkdead(bob, roomsMap)
+		if !stopped(bob) {
+			t.Errorf("%s: expected the goroutines of Bob's client to be stopped", test.name)
+		}
+		bob.linkdeadSince = bob.linkdeadSince.Add(-test.elapsed)
+		s.godReapLinkdead(roomsMap)
+
+		if online := s.onlineClients["Bob"] == bob; online != test.online {
+			t.Errorf("%s: expected Bob online %t, got %t", test.name, test.online, online)
+		}
+		if got := returnedIDs(s); !reflect.DeepEqual(got, test.returned) {
+			t.Errorf("%s: expected the IDs %v back in the pool, got %v", test.name, test.returned, got)
+		}
+		if got := lastMessage(mike); got != test.watched {
+			t.Errorf("%s: expected Mike's last message to be %q, got %q", test.name, test.watched, got)
+		}
+	}
+}
+
+func TestGodDisconnect(t *testing.T) {
+	tests := []struct {
+		name   string
+		online bool // Bob is still online
+
+		returned []ID
+		saved    []string
+		watched  string
+	}{
+		{
+			name:     "online",
+			online:   true,
+			returned: []ID{1},
+			saved:    []string{"Bob"},
+			watched:  "Bob left the game.",
+		},
+		{
+			name: "already disconnected",
+		},
+	}
+
+	for _, test := range tests {
+		s := newSessionServer(sessionTakeover, time.Minute)
+		roomsMap := make(map[string]map[string][][]area.Cube)
+		bob := newSessionClient(1, &area.Player{Nickname: "Bob", Area: "City", Room: "Inn", Position: "1"})
+		mike := newSessionClient(2, &area.Player{Nickname: "Mike", Area: "City", Room: "Inn", Position: "2"})
+		s.onlineClients["Mike"] = mike
+		if test.online {
+			s.onlineClients["Bob"] = bob
+		}
+
+		s.godDisconnect(bob, roomsMap)
+
+		if _, ok := s.onlineClients["Bob"]; ok {
+			t.Errorf("%s: expected Bob to be gone from the online clients", test.name)
+		}
+		if got := returnedIDs(s); !reflect.DeepEqual(got, test.returned) {
+			t.Errorf("%s: expected the IDs %v back in the pool, got %v", test.name, test.returned, got)
+		}
+		var saved []string
+		select {
+		case batch := <-s.autosave.requests:
+			for _, p := range batch {
+				saved = append(saved, p.Nickname)
+			}
+		default:
+		}
+		if !reflect.DeepEqual(saved, test.saved) {
+			t.Errorf("%s: expected %v to be saved, got %v", test.name, test.saved, saved)
+		}
+		if stopped(bob) != test.online {
+			t.Errorf("%s: expected the goroutines of Bob's client stopped %t, got %t", test.name, test.online, stopped(bob))
+		}
+		if got := lastMessage(mike); got != test.watched {
+			t.Errorf("%s: expected Mike's last message to be %q, got %q", test.name, test.watched, got)
 		}
 	}
 }
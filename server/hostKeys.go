@@ -0,0 +1,471 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/boltdb/bolt"
+	"golang.org/x/crypto/ssh"
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+var hostKeyBucket = []byte("host-keys")
+
+// hostKeyTypes are generated unless the operator supplies a key of the same type.
+var hostKeyTypes = []string{ssh.KeyAlgoED25519, ssh.KeyAlgoECDSA256, ssh.KeyAlgoRSA}
+
+// OpenSSH extensions used to let clients learn about new host keys.
+// See https://cvsweb.openbsd.org/src/usr.bin/ssh/PROTOCOL
+const (
+	reqHostKeys      = "hostkeys-00@openssh.com"
+	reqHostKeysProve = "hostkeys-prove-00@openssh.com"
+)
+
+// storedHostKey is a generated host key as kept in the database.
+type storedHostKey struct {
+	Type string `toml:"type"`
+	PEM  string `toml:"pem"`
+	// ActiveFrom is when the key starts signing handshakes.
+	ActiveFrom time.Time `toml:"activeFrom"`
+
+	signer ssh.Signer
+}
+
+func (k *storedHostKey) dbKey() []byte {
+	return []byte(fmt.Sprintf("%s/%d", k.Type, k.ActiveFrom.UnixNano()))
+}
+
+// hostKeys holds the host keys of the server. Generated keys can be rotated:
+// the new keys are advertised to clients right away, but they only start
+// signing handshakes after the grace period so that clients have time to
+// learn them.
+type hostKeys struct {
+	sync.Mutex
+	db    *database
+	grace time.Duration
+	// operator supplied keys by type, never rotated.
+	operator map[string]ssh.Signer
+	// generated keys by type, oldest first.
+	stored map[string][]*storedHostKey
+}
+
+// loadHostKeys reads the operator supplied key files and the generated keys
+// from the database, generating any missing type.
+func loadHostKeys(db *database, files []string, grace time.Duration) (*hostKeys, error) {
+	hk := &hostKeys{
+		db:       db,
+		grace:    grace,
+		operator: make(map[string]ssh.Signer),
+		stored:   make(map[string][]*storedHostKey),
+	}
+
+	for _, file := range files {
+		fileContent, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(fileContent)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		typ := signer.PublicKey().Type()
+		log.Info(fmt.Sprintf("Using %s host key from %s", typ, file))
+		hk.operator[typ] = signer
+	}
+
+	if err := hk.migrateLegacyKey(); err != nil {
+		return nil, err
+	}
+
+	var keys []*storedHostKey
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(hostKeyBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			key := &storedHostKey{}
+			if _, err := toml.Decode(string(v), key); err != nil {
+				return fmt.Errorf("cannot decode host key %s: %v", k, err)
+			}
+			keys = append(keys, key)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if key.signer, err = ssh.ParsePrivateKey([]byte(key.PEM)); err != nil {
+			return nil, fmt.Errorf("cannot parse %s host key: %v", key.Type, err)
+		}
+		hk.stored[key.Type] = append(hk.stored[key.Type], key)
+	}
+	for typ := range hk.stored {
+		sort.Slice(hk.stored[typ], func(i, j int) bool {
+			return hk.stored[typ][i].ActiveFrom.Before(hk.stored[typ][j].ActiveFrom)
+		})
+	}
+
+	now := time.Now()
+	for _, typ := range hostKeyTypes {
+		if _, ok := hk.operator[typ]; ok || len(hk.stored[typ]) > 0 {
+			continue
+		}
+		log.Info(fmt.Sprintf("Generating %s host key", typ))
+		if err := hk.generate(typ, now); err != nil {
+			return nil, err
+		}
+	}
+
+	return hk, hk.prune(now)
+}
+
+// migrateLegacyKey moves the RSA key kept by older servers in the config
+// bucket into the host keys bucket, so clients keep trusting the server.
+func (hk *hostKeys) migrateLegacyKey() error {
+	return hk.db.Update(func(tx *bolt.Tx) error {
+		config := tx.Bucket(configBucket)
+		if config == nil {
+			return nil
+		}
+		legacy := config.Get(configSSHKey)
+		if legacy == nil {
+			return nil
+		}
+		signer, err := ssh.ParsePrivateKey(legacy)
+		if err != nil {
+			return fmt.Errorf("cannot parse legacy host key: %v", err)
+		}
+		key := &storedHostKey{
+			Type: signer.PublicKey().Type(),
+			PEM:  string(legacy),
+		}
+		if err := putHostKey(tx, key); err != nil {
+			return err
+		}
+		log.Info(fmt.Sprintf("Migrated legacy %s host key", key.Type))
+		return config.Delete(configSSHKey)
+	})
+}
+
+func putHostKey(tx *bolt.Tx, key *storedHostKey) error {
+	b, err := tx.CreateBucketIfNotExists(hostKeyBucket)
+	if err != nil {
+		return err
+	}
+	data := &bytes.Buffer{}
+	if err := toml.NewEncoder(data).Encode(key); err != nil {
+		return err
+	}
+	return b.Put(key.dbKey(), data.Bytes())
+}
+
+// generate creates and stores a new key of the given type that signs
+// handshakes from activeFrom on.
+func (hk *hostKeys) generate(typ string, activeFrom time.Time) error {
+	pemBytes, err := genPrivateKey(typ)
+	if err != nil {
+		return err
+	}
+	signer, err := ssh.ParsePrivateKey(pemBytes)
+	if err != nil {
+		return err
+	}
+	key := &storedHostKey{
+		Type:       typ,
+		PEM:        string(pemBytes),
+		ActiveFrom: activeFrom,
+		signer:     signer,
+	}
+	err = hk.db.Update(func(tx *bolt.Tx) error {
+		return putHostKey(tx, key)
+	})
+	if err != nil {
+		return err
+	}
+	hk.stored[typ] = append(hk.stored[typ], key)
+	return nil
+}
+
+// genPrivateKey returns a new PEM encoded private key of the given type.
+func genPrivateKey(typ string) ([]byte, error) {
+	var priv interface{}
+	var err error
+	switch typ {
+	case ssh.KeyAlgoED25519:
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	case ssh.KeyAlgoECDSA256:
+		priv, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case ssh.KeyAlgoRSA:
+		priv, err = rsa.GenerateKey(rand.Reader, 3072)
+	default:
+		return nil, fmt.Errorf("unsupported host key type %q", typ)
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), nil
+}
+
+// active returns the generated key of the given type that signs handshakes at now.
+func (hk *hostKeys) active(typ string, now time.Time) *storedHostKey {
+	keys := hk.stored[typ]
+	for i := len(keys) - 1; i >= 0; i-- {
+		if !keys[i].ActiveFrom.After(now) {
+			return keys[i]
+		}
+	}
+	return nil
+}
+
+// prune deletes the generated keys that have been replaced by a newer active key.
+func (hk *hostKeys) prune(now time.Time) error {
+	return hk.db.Update(func(tx *bolt.Tx) error {
+		for typ, keys := range hk.stored {
+			active := hk.active(typ, now)
+			var kept []*storedHostKey
+			for _, key := range keys {
+				if active != nil && key.ActiveFrom.Before(active.ActiveFrom) {
+					log.Info(fmt.Sprintf("Retiring %s host key %s", typ, ssh.FingerprintSHA256(key.signer.PublicKey())))
+					if b := tx.Bucket(hostKeyBucket); b != nil {
+						if err := b.Delete(key.dbKey()); err != nil {
+							return err
+						}
+					}
+					continue
+				}
+				kept = append(kept, key)
+			}
+			hk.stored[typ] = kept
+		}
+		return nil
+	})
+}
+
+// signers returns the keys that sign handshakes right now.
+func (hk *hostKeys) signers() []ssh.Signer {
+	hk.Lock()
+	defer hk.Unlock()
+
+	now := time.Now()
+	var signers []ssh.Signer
+	for _, signer := range hk.operator {
+		signers = append(signers, signer)
+	}
+	for typ := range hk.stored {
+		if _, ok := hk.operator[typ]; ok {
+			continue
+		}
+		if key := hk.active(typ, now); key != nil {
+			signers = append(signers, key.signer)
+		}
+	}
+	return signers
+}
+
+// advertised returns the keys clients should trust: the ones signing
+// handshakes and the ones that will replace them after a rotation.
+func (hk *hostKeys) advertised() []ssh.Signer {
+	now := time.Now()
+	signers := hk.signers()
+
+	hk.Lock()
+	defer hk.Unlock()
+	for typ, keys := range hk.stored {
+		if _, ok := hk.operator[typ]; ok {
+			continue
+		}
+		for _, key := range keys {
+			if key.ActiveFrom.After(now) {
+				signers = append(signers, key.signer)
+			}
+		}
+	}
+	return signers
+}
+
+// rotate generates a new key for every generated type. The new keys take
+// over once the grace period is over. It returns when that happens.
+func (hk *hostKeys) rotate() (time.Time, error) {
+	hk.Lock()
+	defer hk.Unlock()
+
+	now := time.Now()
+	for _, keys := range hk.stored {
+		if len(keys) > 0 && keys[len(keys)-1].ActiveFrom.After(now) {
+			return time.Time{}, fmt.Errorf("a rotation is already in progress until %s", keys[len(keys)-1].ActiveFrom.Format(time.RFC1123))
+		}
+	}
+	if err := hk.prune(now); err != nil {
+		return time.Time{}, err
+	}
+
+	activeFrom := now.Add(hk.grace)
+	for _, typ := range hostKeyTypes {
+		if _, ok := hk.operator[typ]; ok {
+			continue
+		}
+		if err := hk.generate(typ, activeFrom); err != nil {
+			return time.Time{}, err
+		}
+		log.Info(fmt.Sprintf("Rotating %s host key, the new key takes over at %s", typ, activeFrom.Format(time.RFC1123)))
+	}
+	return activeFrom, nil
+}
+
+// advertise tells the client about all the keys it should trust.
+func (hk *hostKeys) advertise(conn ssh.Conn) {
+	var payload []byte
+	for _, signer := range hk.advertised() {
+		payload = append(payload, ssh.Marshal(struct{ Key []byte }{signer.PublicKey().Marshal()})...)
+	}
+	if _, _, err := conn.SendRequest(reqHostKeys, false, payload); err != nil {
+		log.Debug(fmt.Sprintf("Cannot advertise host keys: %v", err))
+	}
+}
+
+// kexAlgorithm is the signature algorithm an RSA host key signed the key
+// exchange of a connection with, or "" if another key did.
+type kexAlgorithm struct {
+	sync.Mutex
+	algorithm string
+}
+
+func (k *kexAlgorithm) set(algorithm string) {
+	k.Lock()
+	k.algorithm = algorithm
+	k.Unlock()
+}
+
+func (k *kexAlgorithm) get() string {
+	k.Lock()
+	defer k.Unlock()
+	return k.algorithm
+}
+
+// kexSigner is an RSA host key that records in kex the algorithm it signs the
+// key exchange with.
+type kexSigner struct {
+	ssh.AlgorithmSigner
+	kex *kexAlgorithm
+}
+
+// Sign is used when the client settled on ssh-rsa.
+func (s kexSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	s.kex.set(ssh.SigAlgoRSA)
+	return s.AlgorithmSigner.Sign(rand, data)
+}
+
+// SignWithAlgorithm is used when the client settled on rsa-sha2-256 or 512.
+func (s kexSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	if algorithm == "" {
+		s.kex.set(ssh.SigAlgoRSA)
+	} else {
+		s.kex.set(algorithm)
+	}
+	return s.AlgorithmSigner.SignWithAlgorithm(rand, data, algorithm)
+}
+
+// forConnection returns the keys signing the handshake of a new connection.
+// The RSA key records in kex the algorithm it signs the key exchange with.
+func (hk *hostKeys) forConnection(kex *kexAlgorithm) []ssh.Signer {
+	signers := hk.signers()
+	for i, signer := range signers {
+		if as, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+			signers[i] = kexSigner{AlgorithmSigner: as, kex: kex}
+		}
+	}
+	return signers
+}
+
+// serveRequests answers the client asking us to prove that we own the keys
+// we advertised. Any other global request is rejected.
+func (hk *hostKeys) serveRequests(conn ssh.Conn, reqs <-chan *ssh.Request, kex *kexAlgorithm) {
+	for req := range reqs {
+		if req.Type != reqHostKeysProve {
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+			continue
+		}
+		payload, err := hk.prove(conn.SessionID(), req.Payload, kex.get())
+		if err != nil {
+			log.Warn(fmt.Sprintf("Cannot prove host keys: %v", err))
+		}
+		req.Reply(err == nil, payload)
+	}
+}
+
+// prove signs every key blob found in the request payload. OpenSSH checks the
+// proofs of RSA keys with the algorithm the key exchange was signed with,
+// rsaAlgorithm, if an RSA key signed it.
+func (hk *hostKeys) prove(sessionID, payload []byte, rsaAlgorithm string) ([]byte, error) {
+	if rsaAlgorithm == "" {
+		rsaAlgorithm = ssh.SigAlgoRSASHA2512
+	}
+
+	signers := hk.advertised()
+
+	var resp []byte
+	for len(payload) > 0 {
+		if len(payload) < 4 {
+			return nil, errors.New("malformed request")
+		}
+		n := binary.BigEndian.Uint32(payload)
+		if uint32(len(payload)-4) < n {
+			return nil, errors.New("malformed request")
+		}
+		blob := payload[4 : 4+n]
+		payload = payload[4+n:]
+
+		var signer ssh.Signer
+		for _, s := range signers {
+			if bytes.Equal(s.PublicKey().Marshal(), blob) {
+				signer = s
+				break
+			}
+		}
+		if signer == nil {
+			return nil, errors.New("unknown host key")
+		}
+
+		data := ssh.Marshal(struct {
+			Request   string
+			SessionID []byte
+			Key       []byte
+		}{reqHostKeysProve, sessionID, blob})
+
+		var sig *ssh.Signature
+		var err error
+		if as, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+			sig, err = as.SignWithAlgorithm(rand.Reader, data, rsaAlgorithm)
+		} else {
+			sig, err = signer.Sign(rand.Reader, data)
+		}
+		if err != nil {
+			return nil, err
+		}
+		resp = append(resp, ssh.Marshal(struct{ Sig []byte }{ssh.Marshal(sig)})...)
+	}
+	return resp, nil
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func fingerprints(signers []ssh.Signer) map[string]bool {
+	fps := make(map[string]bool)
+	for _, signer := range signers {
+		fps[ssh.FingerprintSHA256(signer.PublicKey())] = true
+	}
+	return fps
+}
+
+func TestHostKeys(t *testing.T) {
+	store, dir := newTestStore(t)
+	defer os.RemoveAll(dir)
+	defer store.db.Close()
+
+	hk, err := loadHostKeys(store.db, nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := fingerprints(hk.signers())
+	if len(first) != len(hostKeyTypes) {
+		t.Fatalf("expected %d host keys, got %d", len(hostKeyTypes), len(first))
+	}
+
+	// Keys must survive a restart.
+	hk, err = loadHostKeys(store.db, nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for fp := range fingerprints(hk.signers()) {
+		if !first[fp] {
+			t.Errorf("unexpected host key %s after reload", fp)
+		}
+	}
+
+	if _, err := hk.rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hk.rotate(); err == nil {
+		t.Error("expected an error for a second rotation during the grace period")
+	}
+	if got := fingerprints(hk.signers()); len(got) != len(first) {
+		t.Errorf("expected the old keys to sign during the grace period, got %d keys", len(got))
+	}
+	if got := fingerprints(hk.advertised()); len(got) != 2*len(first) {
+		t.Errorf("expected old and new keys to be advertised, got %d keys", len(got))
+	}
+
+	// Once the new keys are active the old ones are retired.
+	if err := hk.prune(time.Now().Add(2 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	for typ, keys := range hk.stored {
+		if len(keys) != 1 {
+			t.Errorf("expected one %s key after the grace period, got %d", typ, len(keys))
+			continue
+		}
+		if fp := ssh.FingerprintSHA256(keys[0].signer.PublicKey()); first[fp] {
+			t.Errorf("old %s host key %s was not retired", typ, fp)
+		}
+	}
+}
+
+func TestProveKexAlgorithm(t *testing.T) {
+	store, dir := newTestStore(t)
+	defer os.RemoveAll(dir)
+	defer store.db.Close()
+
+	hk, err := loadHostKeys(store.db, nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rsaKey ssh.PublicKey
+	for _, signer := range hk.signers() {
+		if signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+			rsaKey = signer.PublicKey()
+		}
+	}
+	s := &Server{config: DefaultConfig(), hostKeys: hk}
+
+	tests := []struct {
+		hostKey string
+		kex     string
+		proof   string
+	}{
+		{ssh.SigAlgoRSASHA2256, ssh.SigAlgoRSASHA2256, ssh.SigAlgoRSASHA2256},
+		{ssh.SigAlgoRSASHA2512, ssh.SigAlgoRSASHA2512, ssh.SigAlgoRSASHA2512},
+		{ssh.SigAlgoRSA, ssh.SigAlgoRSA, ssh.SigAlgoRSA},
+		{ssh.KeyAlgoED25519, "", ssh.SigAlgoRSASHA2512},
+	}
+	for _, test := range tests {
+		config, kex := s.sshConfig()
+		config.NoClientAuth = true
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan error, 1)
+		go func() {
+			serverConn, err := l.Accept()
+			if err != nil {
+				done <- err
+				return
+			}
+			conn, _, _, err := ssh.NewServerConn(serverConn, config)
+			if err == nil {
+				conn.Close()
+			}
+			done <- err
+		}()
+		clientConn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, _, _, err := ssh.NewClientConn(clientConn, "", &ssh.ClientConfig{
+			HostKeyCallback:   ssh.InsecureIgnoreHostKey(),
+			HostKeyAlgorithms: []string{test.hostKey},
+		})
+		if err != nil {
+			t.Fatalf("%s: handshake: %v", test.hostKey, err)
+		}
+		sessionID := conn.SessionID()
+		err = <-done
+		conn.Close()
+		l.Close()
+		if err != nil {
+			t.Fatalf("%s: handshake: %v", test.hostKey, err)
+		}
+
+		if got := kex.get(); got != test.kex {
+			t.Errorf("%s: key exchange signed with %q, expected %q", test.hostKey, got, test.kex)
+		}
+		blob := rsaKey.Marshal()
+		payload := ssh.Marshal(struct{ Key []byte }{blob})
+		resp, err := hk.prove(sessionID, payload, kex.get())
+		if err != nil {
+			t.Fatalf("%s: %v", test.hostKey, err)
+		}
+		var proof struct{ Sig []byte }
+		if err := ssh.Unmarshal(resp, &proof); err != nil {
+			t.Fatal(err)
+		}
+		sig := new(ssh.Signature)
+		if err := ssh.Unmarshal(proof.Sig, sig); err != nil {
+			t.Fatal(err)
+		}
+		if sig.Format != test.proof {
+			t.Errorf("%s: proof signed with %q, expected %q", test.hostKey, sig.Format, test.proof)
+		}
+		data := ssh.Marshal(struct {
+			Request   string
+			SessionID []byte
+			Key       []byte
+		}{reqHostKeysProve, sessionID, blob})
+		if err := rsaKey.Verify(data, sig); err != nil {
+			t.Errorf("%s: proof does not verify: %v", test.hostKey, err)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Names of the panels of the game screen.
+const (
+	panelIntro  = "intro"
+	panelMap    = "map"
+	panelExits  = "exits"
+	panelLog    = "log"
+	panelSheet  = "sheet"
+	panelPrompt = "prompt"
+)
+
+// promptHeight is the number of rows below the frame kept for the prompt bar.
+// The screen draws its edges, the prompt bar the input line between them.
+const promptHeight = 3
+
+// defaultLayout is the layout of players that did not pick one.
+const defaultLayout = "classic"
+
+// layoutNode is either a panel or a split of its space between children that
+// are placed side by side (columns) or on top of each other. Children are
+// separated by a line.
+//
+// min and max bound the size of a node along the split of its parent; a max
+// of 0 means no limit. When there is not enough room for the min size of
+// every child, the children with the lowest priority are hidden.
+type layoutNode struct {
+	panel    string
+	columns  bool
+	children []layoutNode
+	min, max int
+	priority int
+}
+
+// layoutPresets are the layouts players can choose from.
+var layoutPresets = map[string]layoutNode{
+	// The character sheet on the left, the room on the top and the messages
+	// next to the map.
+	"classic": {columns: true, children: []layoutNode{
+		{panel: panelSheet, min: 14, max: 20, priority: 1},
+		{min: 30, priority: 5, children: []layoutNode{
+			{panel: panelIntro, min: 4, max: 10, priority: 3},
+			{columns: true, min: 5, priority: 5, children: []layoutNode{
+				{panel: panelLog, min: 12, priority: 5},
+				{min: 19, max: 32, priority: 4, children: []layoutNode{
+					{panel: panelMap, min: 5, priority: 4},
+					{panel: panelExits, min: 1, max: 1, priority: 2},
+				}},
+			}},
+		}},
+	}},
+	// Messages and map only, for small terminals.
+	"compact": {columns: true, children: []layoutNode{
+		{panel: panelLog, min: 12, priority: 5},
+		{min: 19, max: 21, priority: 4, children: []layoutNode{
+			{panel: panelMap, min: 5, priority: 4},
+			{panel: panelExits, min: 1, max: 1, priority: 2},
+		}},
+	}},
+	// The map takes most of the screen.
+	"wide": {columns: true, children: []layoutNode{
+		{min: 24, max: 40, priority: 3, children: []layoutNode{
+			{panel: panelIntro, min: 4, max: 8, priority: 2},
+			{panel: panelLog, min: 5, priority: 3},
+			{panel: panelSheet, min: 9, max: 9, priority: 1},
+		}},
+		{min: 19, priority: 5, children: []layoutNode{
+			{panel: panelMap, min: 5, priority: 5},
+			{panel: panelExits, min: 1, max: 1, priority: 4},
+		}},
+	}},
+}
+
+// layoutNames returns the names of the layout presets in order.
+func layoutNames() []string {
+	var names []string
+	for name := range layoutPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// layoutFor returns the layout preset of the given name, or the default one.
+func layoutFor(name string) layoutNode {
+	if l, ok := layoutPresets[name]; ok {
+		return l
+	}
+	return layoutPresets[defaultLayout]
+}
+
+// rect is a part of the screen, in cells.
+type rect struct {
+	x, y, w, h int
+}
+
+// inner returns the part of a panel its content goes in, leaving a blank
+// column on each side when there is room for it.
+func (r rect) inner() rect {
+	if r.w > 2 {
+		r.x, r.w = r.x+1, r.w-2
+	}
+	return r
+}
+
+// separator is a line between two children of a split.
+type separator struct {
+	rect
+	vertical bool
+}
+
+// placement is where a layout puts the panels on a screen of a given size.
+// Hidden panels are missing from panels.
+type placement struct {
+	width, height int
+	panels        map[string]rect
+	separators    []separator
+}
+
+// place lays out the panels on a screen of the given size: the layout goes
+// inside a frame, with the prompt bar below it.
+func (l layoutNode) place(width, height int) placement {
+	pl := placement{width: width, height: height, panels: make(map[string]rect)}
+	if width < 1 || height < promptHeight {
+		return pl
+	}
+	pl.panels[panelPrompt] = rect{x: 0, y: height - promptHeight, w: width, h: promptHeight}
+
+	// The frame takes a cell on each side.
+	inner := rect{x: 1, y: 1, w: width - 2, h: height - promptHeight - 2}
+	if inner.w > 0 && inner.h > 0 {
+		l.placeIn(inner, &pl)
+	}
+	return pl
+}
+
+func (l layoutNode) placeIn(r rect, pl *placement) {
+	if l.panel != "" {
+		pl.panels[l.panel] = r
+		return
+	}
+
+	length := r.h
+	if l.columns {
+		length = r.w
+	}
+	children := l.fit(length)
+	sizes := grow(children, length)
+
+	offset := 0
+	for i, child := range children {
+		sub, sep := r, separator{rect: r, vertical: l.columns}
+		if l.columns {
+			sub.x, sub.w = r.x+offset, sizes[i]
+			sep.x, sep.w = sub.x+sub.w, 1
+		} else {
+			sub.y, sub.h = r.y+offset, sizes[i]
+			sep.y, sep.h = sub.y+sub.h, 1
+		}
+		child.placeIn(sub, pl)
+		offset += sizes[i] + 1
+		if i < len(children)-1 {
+			pl.separators = append(pl.separators, sep)
+		}
+	}
+}
+
+// fit returns the children that fit in the given length, hiding those with
+// the lowest priority first, the last ones on a tie.
+func (l layoutNode) fit(length int) []layoutNode {
+	children := append([]layoutNode{}, l.children...)
+	for len(children) > 0 {
+		need := len(children) - 1 // separators
+		for _, child := range children {
+			need += child.minSize()
+		}
+		if need <= length {
+			break
+		}
+		lowest := len(children) - 1
+		for i := len(children) - 1; i >= 0; i-- {
+			if children[i].priority < children[lowest].priority {
+				lowest = i
+			}
+		}
+		children = append(children[:lowest], children[lowest+1:]...)
+	}
+	return children
+}
+
+func (l layoutNode) minSize() int {
+	if l.min < 1 {
+		return 1
+	}
+	return l.min
+}
+
+// grow starts every child at its min size and shares the rest of the length
+// between them a cell at a time, children with a higher priority first, until
+// they reach their max size.
+func grow(children []layoutNode, length int) []int {
+	sizes := make([]int, len(children))
+	order := make([]int, len(children))
+	rest := length - (len(children) - 1)
+	for i, child := range children {
+		sizes[i] = child.minSize()
+		rest -= sizes[i]
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return children[order[a]].priority > children[order[b]].priority
+	})
+
+	for rest > 0 {
+		grown := false
+		for _, i := range order {
+			if rest > 0 && (children[i].max == 0 || sizes[i] < children[i].max) {
+				sizes[i]++
+				rest--
+				grown = true
+			}
+		}
+		if !grown {
+			// Every child is at its max size, the rest stays empty.
+			break
+		}
+	}
+	return sizes
+}
+
+// Ways to fit a canvas in a panel.
+const (
+	// alignTop shows the first lines and columns that fit.
+	alignTop = iota
+	// alignCenter centers the canvas, cropping it around its middle.
+	alignCenter
+)
+
+// drawFrame draws the frame around the panels, the lines between them and the
+// edges of the prompt bar.
+func (scr *Screen) drawFrame(pl placement) {
+	if r, ok := pl.panels[panelPrompt]; ok {
+		for x := r.x; x < r.x+r.w; x++ {
+			scr.setCell(r.y, x, rune(230), Attr{})
+			scr.setCell(r.y+r.h-1, x, rune(230), Attr{})
+		}
+	}
+
+	bottom := pl.height - promptHeight - 1
+	for y := 0; y <= bottom; y++ {
+		for x := 0; x < pl.width; x++ {
+			switch {
+			case x == 0 || x == pl.width-1:
+				scr.setCell(y, x, '|', Attr{})
+			case y == 0 || y == bottom:
+				scr.setCell(y, x, '-', Attr{})
+			}
+		}
+	}
+	for _, sep := range pl.separators {
+		for y := sep.y; y < sep.y+sep.h; y++ {
+			for x := sep.x; x < sep.x+sep.w; x++ {
+				if sep.vertical {
+					scr.setCell(y, x, '|', Attr{})
+				} else {
+					scr.setCell(y, x, '-', Attr{})
+				}
+			}
+		}
+	}
+}
+
+// drawPanel copies a canvas into the panel r, leaving a blank column on each
+// side, and clips what does not fit. attrs may be nil.
+func (scr *Screen) drawPanel(r rect, runes [][]rune, attrs [][]Attr, align int) {
+	r = r.inner()
+
+	top, left := 0, 0
+	switch align {
+	case alignCenter:
+		width := 0
+		for _, row := range runes {
+			if len(row) > width {
+				width = len(row)
+			}
+		}
+		// A negative offset centers a small canvas, a positive one crops a
+		// large one.
+		top, left = (len(runes)-r.h)/2, (width-r.w)/2
+	}
+
+	for y := 0; y < r.h; y++ {
+		cy := y + top
+		if cy < 0 || cy >= len(runes) {
+			continue
+		}
+		for x := 0; x < r.w; x++ {
+			cx := x + left
+			if cx < 0 || cx >= len(runes[cy]) {
+				continue
+			}
+			attr := Attr{}
+			if cy < len(attrs) && cx < len(attrs[cy]) {
+				attr = attrs[cy][cx]
+			}
+			scr.setCell(r.y+y, r.x+x, runes[cy][cx], attr)
+		}
+	}
+}
+
+// layoutCommand shows the layout presets or switches the client's player to
+// one of them. It returns the text to show to the client.
+func (s *Server) layoutCommand(c *Client, args []string) string {
+	names := strings.Join(layoutNames(), ", ")
+	current := c.Player.Layout
+	if _, ok := layoutPresets[current]; !ok {
+		current = defaultLayout
+	}
+
+	if len(args) == 0 {
+		return fmt.Sprintf("Layout: %s. Available layouts: %s.\n", current, names)
+	}
+	name := strings.ToLower(args[0])
+	if _, ok := layoutPresets[name]; !ok {
+		return fmt.Sprintf("There is no %q layout. Available layouts: %s.\n", args[0], names)
+	}
+	c.Player.Layout = name
+	s.autosave.markDirty(c.Player)
+	return fmt.Sprintf("Switched to the %s layout.\n", name)
+}
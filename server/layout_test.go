@@ -0,0 +1,122 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLayoutPlace(t *testing.T) {
+	twoColumns := layoutNode{columns: true, children: []layoutNode{
+		{panel: panelLog, min: 10, priority: 2},
+		{panel: panelMap, min: 10, max: 15, priority: 1},
+	}}
+
+	tests := []struct {
+		name          string
+		layout        layoutNode
+		width, height int
+
+		expected map[string]rect
+	}{
+		{
+			name:   "space is shared up to the max size",
+			layout: twoColumns,
+			width:  50, height: 10,
+			// 48 columns inside the frame: 32 + separator + 15.
+			expected: map[string]rect{
+				panelLog:    {x: 1, y: 1, w: 32, h: 5},
+				panelMap:    {x: 34, y: 1, w: 15, h: 5},
+				panelPrompt: {x: 0, y: 7, w: 50, h: 3},
+			},
+		},
+		{
+			name:   "the lowest priority is hidden first",
+			layout: twoColumns,
+			width:  20, height: 10,
+			expected: map[string]rect{
+				panelLog:    {x: 1, y: 1, w: 18, h: 5},
+				panelPrompt: {x: 0, y: 7, w: 20, h: 3},
+			},
+		},
+		{
+			name: "rows",
+			layout: layoutNode{children: []layoutNode{
+				{panel: panelIntro, min: 2, max: 2, priority: 1},
+				{panel: panelLog, priority: 2},
+			}},
+			width: 20, height: 12,
+			expected: map[string]rect{
+				panelIntro:  {x: 1, y: 1, w: 18, h: 2},
+				panelLog:    {x: 1, y: 4, w: 18, h: 4},
+				panelPrompt: {x: 0, y: 9, w: 20, h: 3},
+			},
+		},
+		{
+			name:   "no room inside the frame",
+			layout: twoColumns,
+			width:  20, height: 4,
+			expected: map[string]rect{
+				panelPrompt: {x: 0, y: 1, w: 20, h: 3},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		if got := test.layout.place(test.width, test.height).panels; !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestLayoutPresets(t *testing.T) {
+	for _, name := range layoutNames() {
+		for _, size := range [][2]int{{120, 40}, {80, 24}, {40, 12}, {10, 10}} {
+			width, height := size[0], size[1]
+			pl := layoutPresets[name].place(width, height)
+
+			// Every cell of the frame belongs to at most one panel.
+			owner := make(map[[2]int]string)
+			for panel, r := range pl.panels {
+				if r.w < 1 || r.h < 1 || r.x < 0 || r.y < 0 || r.x+r.w > width || r.y+r.h > height {
+					t.Errorf("%s at %dx%d: panel %s is off the screen: %v", name, width, height, panel, r)
+				}
+				for y := r.y; y < r.y+r.h; y++ {
+					for x := r.x; x < r.x+r.w; x++ {
+						if other, ok := owner[[2]int{x, y}]; ok {
+							t.Fatalf("%s at %dx%d: panels %s and %s overlap", name, width, height, panel, other)
+						}
+						owner[[2]int{x, y}] = panel
+					}
+				}
+			}
+			if _, ok := pl.panels[panelMap]; !ok && width >= 40 {
+				t.Errorf("%s at %dx%d: the map is hidden", name, width, height)
+			}
+		}
+	}
+}
+
+func TestDrawPanel(t *testing.T) {
+	canvas := [][]rune{[]rune("abcde"), []rune("fghij"), []rune("klmno")}
+
+	tests := []struct {
+		name  string
+		align int
+
+		expected []string
+	}{
+		{name: "top", align: alignTop, expected: []string{" abc ", " fgh "}},
+		{name: "center", align: alignCenter, expected: []string{" bcd ", " ghi "}},
+	}
+
+	for _, test := range tests {
+		scr := NewScreen(5, 5)
+		scr.clear()
+		scr.drawPanel(rect{x: 0, y: 0, w: 5, h: 2}, canvas, nil, test.align)
+		for y, expected := range test.expected {
+			if got := string(scr.screenRunes[y]); got != expected {
+				t.Errorf("%s: expected row %d to be %q, got %q", test.name, y, expected, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// msgCategory tells what a message is about, which decides its color.
+type msgCategory int
+
+const (
+	msgSystem msgCategory = iota // replies to commands and server notices
+	msgRoom                      // what happens in the room
+	msgSay                       // what players say
+	msgTell                      // private messages
+	msgCombat                    // fights
+)
+
+// categoryAttrs are the colors of the message categories.
+var categoryAttrs = map[msgCategory]Attr{
+	msgSystem: {Fg: Yellow},
+	msgRoom:   {},
+	msgSay:    {Fg: BrightWhite, Style: StyleBold},
+	msgTell:   {Fg: BrightMagenta},
+	msgCombat: {Fg: BrightRed},
+}
+
+// timestampAttr is the color of the time of the messages.
+var timestampAttr = Attr{Fg: BrightBlack}
+
+// timestampFormat is how the time of a message is shown.
+const timestampFormat = "15:04"
+
+type logEntry struct {
+	at       time.Time
+	category msgCategory
+	text     string
+}
+
+// messageLog is the scrollback of the messages shown to a client. It keeps
+// the last max messages. The log belongs to the God goroutine.
+type messageLog struct {
+	entries []logEntry
+	max     int
+
+	// scroll is how many lines the view is above the last one.
+	scroll int
+	// width and height of the last view, to scroll by pages and keep the
+	// view in place when messages arrive.
+	width, height int
+}
+
+func newMessageLog(max int) *messageLog {
+	return &messageLog{max: max}
+}
+
+// add appends a message. Each line of text is a message of its own.
+func (l *messageLog) add(category msgCategory, text string) {
+	now := time.Now()
+	for _, line := range splitLines(text) {
+		e := logEntry{at: now, category: category, text: line}
+		l.entries = append(l.entries, e)
+		if l.scroll > 0 {
+			// Keep showing the same lines.
+			lines, _ := e.lines(l.width)
+			l.scroll += len(lines)
+		}
+	}
+	if over := len(l.entries) - l.max; over > 0 {
+		l.entries = append(l.entries[:0], l.entries[over:]...)
+	}
+}
+
+// page scrolls the view a page up (back in time) or down.
+func (l *messageLog) page(up bool) {
+	step := l.height - 1
+	if step < 1 {
+		step = 1
+	}
+	if up {
+		l.scroll += step
+	} else {
+		l.scroll -= step
+	}
+	if l.scroll < 0 {
+		l.scroll = 0
+	}
+	// view clamps scrolling past the oldest message.
+}
+
+// view returns the lines that fit in a panel of the given size. While the
+// view is scrolled up, its last line tells how much is below.
+func (l *messageLog) view(width, height int) ([][]rune, [][]Attr) {
+	l.width, l.height = width, height
+	if width < 1 || height < 1 {
+		return nil, nil
+	}
+
+	// Wrap the messages from the newest, only as far as the view goes.
+	var runes [][]rune
+	var attrs [][]Attr
+	for i := len(l.entries) - 1; i >= 0 && len(runes) < l.scroll+height; i-- {
+		r, a := l.entries[i].lines(width)
+		runes, attrs = append(r, runes...), append(a, attrs...)
+	}
+	if max := len(runes) - height; l.scroll > max {
+		l.scroll = max
+		if l.scroll < 0 {
+			l.scroll = 0
+		}
+	}
+
+	end := len(runes) - l.scroll
+	start := end - height
+	if start < 0 {
+		start = 0
+	}
+	runes, attrs = runes[start:end], attrs[start:end]
+
+	if l.scroll > 0 {
+		// The line under it is hidden as well.
+		more := []rune(fmt.Sprintf("-- %d more lines, PageDown --", l.scroll+1))
+		if len(more) > width {
+			more = more[:width]
+		}
+		runes[len(runes)-1] = more
+		attrs[len(attrs)-1] = fillAttrs(len(more), Attr{Style: StyleReverse})
+	}
+	return runes, attrs
+}
+
+// lines returns the message wrapped to the given width, after its time.
+func (e logEntry) lines(width int) ([][]rune, [][]Attr) {
+	stamp := e.at.Format(timestampFormat) + " "
+	indent := len(stamp)
+	if indent > width/2 {
+		// Too narrow for the time.
+		stamp, indent = "", 0
+	}
+
+	var runes [][]rune
+	var attrs [][]Attr
+	for i, text := range wrap([]rune(e.text), width-indent) {
+		line := make([]rune, indent, indent+len(text))
+		for x := range line {
+			line[x] = ' '
+		}
+		if i == 0 {
+			copy(line, []rune(stamp))
+		}
+		line = append(line, text...)
+		lineAttrs := fillAttrs(len(line), categoryAttrs[e.category])
+		if i == 0 {
+			copy(lineAttrs, fillAttrs(indent, timestampAttr))
+		}
+		runes = append(runes, line)
+		attrs = append(attrs, lineAttrs)
+	}
+	return runes, attrs
+}
+
+// wrap breaks text into lines of at most width runes, at spaces where
+// possible.
+func wrap(text []rune, width int) [][]rune {
+	if width < 1 {
+		return nil
+	}
+	var lines [][]rune
+	for len(text) > width {
+		cut := width
+		for i := width; i > 0; i-- {
+			if text[i] == ' ' {
+				cut = i
+				break
+			}
+		}
+		lines = append(lines, text[:cut])
+		text = text[cut:]
+		for len(text) > 0 && text[0] == ' ' {
+			text = text[1:]
+		}
+	}
+	return append(lines, text)
+}
+
+// splitLines returns the non-empty lines of text.
+func splitLines(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimRight(line, "\r"); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func fillAttrs(n int, attr Attr) []Attr {
+	attrs := make([]Attr, n)
+	for i := range attrs {
+		attrs[i] = attr
+	}
+	return attrs
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestWrap(t *testing.T) {
+	tests := []struct {
+		text  string
+		width int
+
+		expected []string
+	}{
+		{text: "short", width: 10, expected: []string{"short"}},
+		{text: "two words fit", width: 9, expected: []string{"two words", "fit"}},
+		{text: "averyveryverylongword", width: 8, expected: []string{"averyver", "yverylon", "gword"}},
+		{text: "a b", width: 0, expected: nil},
+	}
+
+	for _, test := range tests {
+		var got []string
+		for _, line := range wrap([]rune(test.text), test.width) {
+			got = append(got, string(line))
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("wrap(%q, %d): expected %q, got %q", test.text, test.width, test.expected, got)
+		}
+	}
+}
+
+// texts returns the view without timestamps.
+func texts(l *messageLog, width, height int) []string {
+	runes, _ := l.view(width, height)
+	var lines []string
+	for _, line := range runes {
+		if len(line) > len(timestampFormat)+1 && line[2] == ':' {
+			line = line[len(timestampFormat)+1:]
+		}
+		lines = append(lines, string(line))
+	}
+	return lines
+}
+
+func TestMessageLog(t *testing.T) {
+	l := newMessageLog(5)
+	for i := 1; i <= 7; i++ {
+		l.add(msgRoom, fmt.Sprintf("message %d", i))
+	}
+
+	if len(l.entries) != 5 {
+		t.Fatalf("expected the history to keep 5 messages, got %d", len(l.entries))
+	}
+	if got, expected := texts(l, 40, 3), []string{"message 5", "message 6", "message 7"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected the last messages, got %q", got)
+	}
+
+	l.page(true)
+	if got, expected := texts(l, 40, 3), []string{"message 3", "message 4", "-- 3 more lines, PageDown --"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("page up: expected %q, got %q", expected, got)
+	}
+
+	// Scrolling stops at the oldest message.
+	l.page(true)
+	l.page(true)
+	if got, expected := texts(l, 40, 3), []string{"message 3", "message 4", "-- 3 more lines, PageDown --"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("page up at the top: expected %q, got %q", expected, got)
+	}
+
+	// New messages do not move a scrolled view, unless the lines it shows
+	// drop out of the history.
+	l.add(msgSay, "message 8\nmessage 9")
+	if got, expected := texts(l, 40, 3), []string{"message 5", "message 6", "-- 3 more lines, PageDown --"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("message while scrolled: expected %q, got %q", expected, got)
+	}
+
+	l.page(false)
+	l.page(false)
+	if got, expected := texts(l, 40, 3), []string{"message 7", "message 8", "message 9"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("page down: expected %q, got %q", expected, got)
+	}
+
+	// Long messages wrap below their time.
+	l.add(msgSystem, "a message too long for the panel")
+	if got, expected := texts(l, 20, 3), []string{"a message too", "      long for the", "      panel"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrap: expected %q, got %q", expected, got)
+	}
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gothyra/thyra/area"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// npc is an NPC of an area file while the server runs.
+type npc struct {
+	area.NPC
+	Area, Room string
+	// key tells NPCs with the same name apart.
+	key string
+	// home is the cube the NPC starts on and comes back to.
+	home string
+	// Damage is how many hit points the NPC lost.
+	Damage int
+	dead   bool
+	// respawn brings the NPC back once defeated.
+	respawn *timer
+}
+
+// newNPC places an NPC listed in a room of an area. NPCs that do not say how
+// tough they are get the stats of a weak commoner.
+func newNPC(areaName, roomName string, id int, n area.NPC) *npc {
+	if n.HP <= 0 {
+		n.HP = 4
+	}
+	if n.AC <= 0 {
+		n.AC = 10
+	}
+	if n.Weapondie <= 0 {
+		n.Weapon, n.Weapondie = "fist", 3
+	}
+	for _, attr := range []*int{&n.STR, &n.DEX, &n.CON, &n.INT, &n.WIS, &n.CHA} {
+		if *attr <= 0 {
+			*attr = 10
+		}
+	}
+	return &npc{NPC: n, Area: areaName, Room: roomName, home: n.Position, key: fmt.Sprintf("%s/%s/%s#%d", areaName, roomName, n.Name, id)}
+}
+
+// npcsIn returns the living NPCs of a room.
+func (w *world) npcsIn(areaName, roomName string) []*npc {
+	var npcs []*npc
+	for _, n := range w.npcs {
+		if !n.dead && n.Area == areaName && n.Room == roomName {
+			npcs = append(npcs, n)
+		}
+	}
+	return npcs
+}
+
+// findNPC returns the living NPC of the room called name, or else the first
+// one with a word of its name starting with name. It returns nil if there is
+// none.
+func (w *world) findNPC(areaName, roomName, name string) *npc {
+	var found *npc
+	for _, n := range w.npcsIn(areaName, roomName) {
+		if strings.EqualFold(n.Name, name) {
+			return n
+		}
+		for _, word := range strings.Fields(n.Name) {
+			if found == nil && strings.HasPrefix(strings.ToLower(word), strings.ToLower(name)) {
+				found = n
+			}
+		}
+	}
+	return found
+}
+
+// npcAt returns the living NPC standing on a cube, or nil.
+func (w *world) npcAt(areaName, roomName, cube string) *npc {
+	for _, n := range w.npcsIn(areaName, roomName) {
+		if n.Position == cube {
+			return n
+		}
+	}
+	return nil
+}
+
+// godNPCActions makes every living NPC that is not fighting do one of its
+// actions or, if it wanders, walk to a free cube next to it.
+func (s *Server) godNPCActions(roomsMap map[string]map[string][][]area.Cube) {
+	w := s.world
+	changed := make(map[string][]Client)
+	for _, n := range w.npcs {
+		choices := len(n.Actions)
+		if n.Wander {
+			choices++
+		}
+		if n.dead || w.fights[n.key] != nil || choices == 0 {
+			continue
+		}
+		room := s.OnlineClientsGetByRoom(n.Area, n.Room)
+		if choice := w.dice.Roll(choices) - 1; choice < len(n.Actions) {
+			godMessage(room, msgRoom, fmt.Sprintf("%s %s", n.Name, n.Actions[choice]))
+		} else if !s.npcWander(n, room, roomsMap) {
+			continue
+		}
+		changed[n.Area+"/"+n.Room] = room
+	}
+	for _, room := range changed {
+		s.godPrintRoom(room, roomsMap)
+	}
+}
+
+// npcWander moves n to a free cube next to it in its room, where online are
+// the clients. It reports whether the NPC moved.
+func (s *Server) npcWander(n *npc, online []Client, roomsMap map[string]map[string][][]area.Cube) bool {
+	exits := area.FindExits(roomsMap[n.Area][n.Room], n.Area, n.Room, n.Position)
+	var free []string
+	for _, d := range area.Directions {
+		to, ok := exits[d]
+		if !ok || to.Door {
+			continue
+		}
+		// Nobody is called "", so every player blocks the way.
+		if ok, _ := isCubeAvailable(Client{Player: &area.Player{}}, online, s.world, to.Area, to.Room, to.CubeID); ok {
+			free = append(free, to.CubeID)
+		}
+	}
+	if len(free) == 0 {
+		return false
+	}
+	n.Position = free[s.world.dice.Roll(len(free))-1]
+	return true
+}
+
+// godResetAreas puts the areas back the way they started: NPCs that are not
+// fighting come back if defeated, heal and return to their cube.
+func (s *Server) godResetAreas(roomsMap map[string]map[string][][]area.Cube) {
+	w := s.world
+	changed := make(map[string][]Client)
+	back := 0
+	for _, n := range w.npcs {
+		if w.fights[n.key] != nil || (!n.dead && n.Damage == 0 && n.Position == n.home) {
+			continue
+		}
+		room := s.OnlineClientsGetByRoom(n.Area, n.Room)
+		if n.dead {
+			if n.respawn != nil {
+				n.respawn.cancel()
+			}
+			godMessage(room, msgRoom, fmt.Sprintf("%s appears.", n.Name))
+			n.dead, n.Position = false, n.home
+			back++
+		} else if free, _ := isCubeAvailable(Client{Player: &area.Player{}}, room, w, n.Area, n.Room, n.home); free {
+			n.Position = n.home
+		}
+		n.Damage = 0
+		changed[n.Area+"/"+n.Room] = room
+	}
+	log.Info(fmt.Sprintf("Areas reset, %d NPCs came back.", back))
+	for _, room := range changed {
+		s.godPrintRoom(room, roomsMap)
+	}
+}
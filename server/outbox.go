@@ -0,0 +1,217 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// Values of output_overflow.
+const (
+	overflowDrop       = "drop"
+	overflowDisconnect = "disconnect"
+)
+
+// outItem is a write waiting in an outbox. Frames only send what changed
+// since the previous frame; repaint returns the whole screen instead, which
+// replaces the frames dropped from a full queue.
+type outItem struct {
+	data    []byte
+	frame   bool
+	repaint func() []byte
+}
+
+// maxOutboxBytes is how many bytes an outbox holds at most, whatever the
+// number of writes. A single write always fits an empty outbox.
+const maxOutboxBytes = 1 << 20
+
+// outbox queues the output of a client so that writing to a slow terminal
+// never blocks the caller. The queue holds at most max writes and maxBytes
+// bytes; what does not fit is dropped, or joins the last write for input
+// echo. A writer goroutine sends the queue to the connection. Reads go
+// straight to the connection.
+type outbox struct {
+	sync.Mutex
+	rw       io.ReadWriter
+	items    []outItem
+	size     int // bytes queued
+	max      int
+	maxBytes int
+	policy   string
+	// stale is set when the client no longer shows the frames sent, because
+	// some were dropped or its screen was cleared.
+	stale bool
+	// overflow is called once the queue is full with the disconnect policy.
+	overflow func()
+
+	ready chan struct{}
+	done  chan struct{}
+}
+
+func newOutbox(rw io.ReadWriter, max int, policy string) *outbox {
+	return &outbox{
+		rw:       rw,
+		max:      max,
+		maxBytes: maxOutboxBytes,
+		policy:   policy,
+		ready:    make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+}
+
+// Read reads from the connection.
+func (o *outbox) Read(p []byte) (int, error) {
+	return o.rw.Read(p)
+}
+
+// Write queues p. It never blocks.
+func (o *outbox) Write(p []byte) (int, error) {
+	// The caller may reuse p.
+	data := make([]byte, len(p))
+	copy(data, p)
+	o.enqueue(outItem{data: data})
+	return len(p), nil
+}
+
+// writeFrame queues a screen update. repaint is called instead if older
+// frames had to be dropped to make room for it.
+func (o *outbox) writeFrame(p []byte, repaint func() []byte) {
+	o.enqueue(outItem{data: p, frame: true, repaint: repaint})
+}
+
+func (o *outbox) enqueue(item outItem) {
+	o.Lock()
+	if len(o.items) >= o.max || o.tooBig(item) {
+		if o.policy == overflowDisconnect {
+			overflow := o.overflow
+			o.overflow = nil
+			o.Unlock()
+			if overflow != nil {
+				log.Warn("Output queue is full, disconnecting the client.")
+				overflow()
+			}
+			return
+		}
+		if o.dropFrames() && item.frame {
+			// The dropped changes are part of the repaint.
+			item.data = item.repaint()
+			o.stale = false
+		}
+	}
+	switch {
+	case o.tooBig(item):
+		// Too much is waiting for a client that does not read: the write is
+		// dropped and the next frame will repaint.
+		log.Debug(fmt.Sprintf("Dropped %d bytes from a full output queue.", len(item.data)))
+		o.stale = true
+		o.Unlock()
+		return
+	case len(o.items) >= o.max && item.frame:
+		// Nothing but input echo queued: the next frame will repaint.
+		o.stale = true
+		o.Unlock()
+		return
+	case len(o.items) >= o.max:
+		// Frames do not repaint the prompt, so echo cannot be dropped. It
+		// joins the last write instead of taking a place of its own.
+		last := &o.items[len(o.items)-1]
+		last.data = append(last.data, item.data...)
+	default:
+		o.items = append(o.items, item)
+	}
+	o.size += len(item.data)
+	o.Unlock()
+	o.signal()
+}
+
+// tooBig reports whether item does not fit in the bytes left in the queue.
+func (o *outbox) tooBig(item outItem) bool {
+	return len(o.items) > 0 && o.size+len(item.data) > o.maxBytes
+}
+
+// signal wakes the writer up.
+func (o *outbox) signal() {
+	select {
+	case o.ready <- struct{}{}:
+	default:
+	}
+}
+
+// dropFrames removes the queued frames, keeping everything else in order.
+// It reports whether any frame was dropped.
+func (o *outbox) dropFrames() bool {
+	kept := o.items[:0]
+	o.size = 0
+	for _, item := range o.items {
+		if !item.frame {
+			kept = append(kept, item)
+			o.size += len(item.data)
+		}
+	}
+	dropped := len(kept) < len(o.items)
+	if dropped {
+		log.Debug(fmt.Sprintf("Dropped %d frames from a full output queue.", len(o.items)-len(kept)))
+		o.stale = true
+	}
+	o.items = kept
+	return dropped
+}
+
+// markStale records that the client's screen was cleared.
+func (o *outbox) markStale() {
+	o.Lock()
+	o.stale = true
+	o.Unlock()
+}
+
+// takeStale reports whether the client's screen needs a full redraw since it
+// was last called.
+func (o *outbox) takeStale() bool {
+	o.Lock()
+	defer o.Unlock()
+	stale := o.stale
+	o.stale = false
+	return stale
+}
+
+// take empties the queue, returning everything in it as a single write.
+func (o *outbox) take() []byte {
+	o.Lock()
+	defer o.Unlock()
+	var buf []byte
+	for _, item := range o.items {
+		buf = append(buf, item.data...)
+	}
+	o.items = nil
+	o.size = 0
+	return buf
+}
+
+// writer sends the queued output to the connection until quit or stopCh is
+// closed, then flushes what is left.
+func (o *outbox) writer(quit, stopCh <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer close(o.done)
+
+	for {
+		select {
+		case <-o.ready:
+			if buf := o.take(); len(buf) > 0 {
+				if _, err := o.rw.Write(buf); err != nil {
+					log.Debug(fmt.Sprintf("writer is exiting: %v", err))
+					return
+				}
+			}
+		case <-quit:
+			o.rw.Write(o.take())
+			log.Info("writer is exiting.")
+			return
+		case <-stopCh:
+			o.rw.Write(o.take())
+			log.Info("writer is exiting.")
+			return
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestOutboxOverflow(t *testing.T) {
+	tests := []struct {
+		name string
+
+		policy string
+		// maxBytes is the byte limit of the queue, 0 for the default.
+		maxBytes int
+		writes   []outItem
+
+		expected     string
+		stale        bool
+		disconnected bool
+	}{
+		{
+			name:   "fits",
+			policy: overflowDrop,
+			writes: []outItem{{data: []byte("a")}, {data: []byte("F1"), frame: true}},
+
+			expected: "aF1",
+		},
+		{
+			name:   "drop repaints instead of the dropped frames",
+			policy: overflowDrop,
+			writes: []outItem{
+				{data: []byte("F1"), frame: true},
+				{data: []byte("a")},
+				{data: []byte("F2"), frame: true},
+				{data: []byte("F3"), frame: true},
+			},
+
+			expected: "aR3",
+		},
+		{
+			name:   "dropping for echo repaints the next frame",
+			policy: overflowDrop,
+			writes: []outItem{
+				{data: []byte("F1"), frame: true},
+				{data: []byte("a")},
+				{data: []byte("b")},
+				{data: []byte("c")},
+			},
+
+			expected: "abc",
+			stale:    true,
+		},
+		{
+			name:   "drop skips frames when only echo is queued",
+			policy: overflowDrop,
+			writes: []outItem{
+				{data: []byte("a")},
+				{data: []byte("b")},
+				{data: []byte("c")},
+				{data: []byte("F1"), frame: true},
+			},
+
+			expected: "abc",
+			stale:    true,
+		},
+		{
+			name:   "echo joins the last write of a full queue",
+			policy: overflowDrop,
+			writes: []outItem{
+				{data: []byte("a")},
+				{data: []byte("b")},
+				{data: []byte("c")},
+				{data: []byte("d")},
+				{data: []byte("e")},
+			},
+
+			expected: "abcde",
+		},
+		{
+			name:     "echo past the byte limit is dropped",
+			policy:   overflowDrop,
+			maxBytes: 4,
+			writes: []outItem{
+				{data: []byte("a")},
+				{data: []byte("b")},
+				{data: []byte("c")},
+				{data: []byte("de")},
+				{data: []byte("f")},
+			},
+
+			expected: "abcf",
+			stale:    true,
+		},
+		{
+			name:     "frames make room under the byte limit",
+			policy:   overflowDrop,
+			maxBytes: 5,
+			writes: []outItem{
+				{data: []byte("F1"), frame: true},
+				{data: []byte("a")},
+				{data: []byte("F2"), frame: true},
+				{data: []byte("F3"), frame: true},
+			},
+
+			expected: "aR3",
+		},
+		{
+			name:     "disconnect past the byte limit",
+			policy:   overflowDisconnect,
+			maxBytes: 3,
+			writes:   []outItem{{data: []byte("ab")}, {data: []byte("cd")}},
+
+			expected:     "ab",
+			disconnected: true,
+		},
+		{
+			name:   "disconnect",
+			policy: overflowDisconnect,
+			writes: []outItem{
+				{data: []byte("F1"), frame: true},
+				{data: []byte("F2"), frame: true},
+				{data: []byte("F3"), frame: true},
+				{data: []byte("F4"), frame: true},
+			},
+
+			expected:     "F1F2F3",
+			disconnected: true,
+		},
+	}
+
+	for _, test := range tests {
+		o := newOutbox(&bytes.Buffer{}, 3, test.policy)
+		if test.maxBytes > 0 {
+			o.maxBytes = test.maxBytes
+		}
+		disconnected := false
+		o.overflow = func() { disconnected = true }
+
+		for _, w := range test.writes {
+			if w.frame {
+				repaint := []byte("R" + string(w.data[1:]))
+				o.writeFrame(w.data, func() []byte { return repaint })
+			} else {
+				o.Write(w.data)
+			}
+		}
+
+		if len(o.items) > 3 {
+			t.Errorf("%s: expected at most 3 writes queued, got %d", test.name, len(o.items))
+		}
+		if o.size > o.maxBytes {
+			t.Errorf("%s: expected at most %d bytes queued, got %d", test.name, o.maxBytes, o.size)
+		}
+		if got := string(o.take()); got != test.expected {
+			t.Errorf("%s: expected output %q, got %q", test.name, test.expected, got)
+		}
+		if got := o.takeStale(); got != test.stale {
+			t.Errorf("%s: expected stale %t, got %t", test.name, test.stale, got)
+		}
+		if disconnected != test.disconnected {
+			t.Errorf("%s: expected disconnected %t, got %t", test.name, test.disconnected, disconnected)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/gothyra/thyra/area"
+
+	"github.com/BurntSushi/toml"
+	"github.com/boltdb/bolt"
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// ErrPlayerNotFound is returned by a PlayerStore for unknown nicknames.
+var ErrPlayerNotFound = errors.New("player not found")
+
+// PlayerStore persists players keyed by their nickname.
+type PlayerStore interface {
+	// Load returns the player stored under nickname, or ErrPlayerNotFound.
+	Load(nickname string) (*area.Player, error)
+	// Save stores all the given players in a single transaction. Either all
+	// of them are saved or none is.
+	Save(players ...area.Player) error
+}
+
+// boltPlayerStore keeps TOML-encoded players in the players bucket.
+type boltPlayerStore struct {
+	db *database
+}
+
+func newBoltPlayerStore(db *database) *boltPlayerStore {
+	return &boltPlayerStore{db: db}
+}
+
+func (ps *boltPlayerStore) Load(nickname string) (*area.Player, error) {
+	var data []byte
+	err := ps.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(playerBucket)
+		if b == nil {
+			return nil
+		}
+		// The value is only valid during the transaction.
+		if v := b.Get([]byte(nickname)); v != nil {
+			data = append([]byte{}, v...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, ErrPlayerNotFound
+	}
+
+	player := &area.Player{}
+	if _, err := toml.Decode(string(data), player); err != nil {
+		return nil, fmt.Errorf("cannot decode player %q: %v", nickname, err)
+	}
+	return player, nil
+}
+
+func (ps *boltPlayerStore) Save(players ...area.Player) error {
+	return ps.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(playerBucket)
+		if err != nil {
+			return err
+		}
+		for _, player := range players {
+			if !isValidUsername(player.Nickname) {
+				return fmt.Errorf("invalid username: %s", player.Nickname)
+			}
+			data := &bytes.Buffer{}
+			if err := toml.NewEncoder(data).Encode(player); err != nil {
+				return err
+			}
+			if err := b.Put([]byte(player.Nickname), data.Bytes()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// importPlayers copies every <nickname>.toml player file found in dir into
+// the store. Players that already exist in the store are left untouched.
+// It returns the number of imported players.
+func importPlayers(store PlayerStore, dir string) (int, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.toml"))
+	if err != nil {
+		return 0, err
+	}
+
+	var players []area.Player
+	for _, file := range files {
+		fileContent, err := ioutil.ReadFile(file)
+		if err != nil {
+			return 0, err
+		}
+		var player area.Player
+		if _, err := toml.Decode(string(fileContent), &player); err != nil {
+			return 0, fmt.Errorf("%s could not be unmarshaled: %v", file, err)
+		}
+		if player.Nickname == "" {
+			player.Nickname = strings.TrimSuffix(filepath.Base(file), ".toml")
+		}
+
+		if _, err := store.Load(player.Nickname); err == nil {
+			log.Info(fmt.Sprintf("Player %q already exists, skipping %s", player.Nickname, file))
+			continue
+		} else if err != ErrPlayerNotFound {
+			return 0, err
+		}
+		log.Info(fmt.Sprintf("Importing player %q from %s", player.Nickname, file))
+		players = append(players, player)
+	}
+
+	if err := store.Save(players...); err != nil {
+		return 0, err
+	}
+	return len(players), nil
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gothyra/thyra/area"
+	"github.com/gothyra/thyra/game"
+)
+
+func newTestStore(t *testing.T) (*boltPlayerStore, string) {
+	dir, err := ioutil.TempDir("", "thyra-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := newDatabase(filepath.Join(dir, "thyra.db"), false)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return newBoltPlayerStore(db), dir
+}
+
+func TestBoltPlayerStore(t *testing.T) {
+	store, dir := newTestStore(t)
+	defer os.RemoveAll(dir)
+	defer store.db.Close()
+
+	if _, err := store.Load("Mike"); err != ErrPlayerNotFound {
+		t.Fatalf("expected ErrPlayerNotFound, got: %v", err)
+	}
+
+	mike := area.Player{
+		Nickname: "Mike",
+		PC:       game.PC{STR: 15, HP: 4, Class: "Commoner", Weapon: "dagger"},
+		Area:     "City",
+		Room:     "Inn",
+		Position: "22",
+	}
+	seran := area.Player{Nickname: "Seran", Area: "Arena", Room: "Cage", Position: "2"}
+	if err := store.Save(mike, seran); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []area.Player{mike, seran} {
+		got, err := store.Load(expected.Nickname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(*got, expected) {
+			t.Errorf("expected player: %#v\ngot: %#v", expected, *got)
+		}
+	}
+
+	// A failing player must not leave the others half-saved.
+	mike.Position = "23"
+	if err := store.Save(mike, area.Player{Nickname: "not valid"}); err == nil {
+		t.Fatal("expected an error for an invalid nickname")
+	}
+	got, err := store.Load("Mike")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Position != "22" {
+		t.Errorf("expected the failed save to be rolled back, got position %s", got.Position)
+	}
+}
+
+func TestImportPlayers(t *testing.T) {
+	store, dir := newTestStore(t)
+	defer os.RemoveAll(dir)
+	defer store.db.Close()
+
+	playerDir := filepath.Join(dir, "player")
+	if err := os.Mkdir(playerDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"Mike.toml":  "nickname = \"Mike\"\nSTR = 15\narea = \"City\"\nroom = \"Inn\"\nposition = \"22\"\n",
+		"Seran.toml": "nickname = \"Seran\"\narea = \"City\"\nroom = \"Inn\"\nposition = \"3\"\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(playerDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Seran already played on the new server and must not be overwritten.
+	if err := store.Save(area.Player{Nickname: "Seran", Position: "10"}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := importPlayers(store, playerDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 imported player, got %d", n)
+	}
+
+	mike, err := store.Load("Mike")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mike.STR != 15 || mike.Position != "22" {
+		t.Errorf("unexpected imported player: %#v", mike)
+	}
+	seran, err := store.Load("Seran")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seran.Position != "10" {
+		t.Errorf("expected existing player to be kept, got position %s", seran.Position)
+	}
+}
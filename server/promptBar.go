@@ -152,13 +152,16 @@ func (p *PromptBar) promptBar(c *Client, eventCh chan Event, stopCh <-chan struc
 		var b []byte
 		select {
 		case b = <-p.promptChan:
+		case <-c.quit:
+			log.Info("promptBar is exiting.")
+			return
 		case <-stopCh:
 			log.Info("promptBar is exiting.")
 			return
 		}
 
 		// Parse Arrows
-		if len(b) == 3 && b[0] == ansi.Esc && b[1] == 91 {
+		if len(b) >= 3 && b[0] == ansi.Esc && b[1] == 91 {
 			cursorBehavor := []byte{0, 0, 0}
 			switch arrow := b[2]; {
 
@@ -187,105 +190,118 @@ func (p *PromptBar) promptBar(c *Client, eventCh chan Event, stopCh <-chan struc
 				}
 			}
 			c.conn.Write(cursorBehavor)
-		} else {
-			p.rollback = 0
-		}
 
-		switch n := b[0]; {
-
-		// Check Special chars 1st part
-		case n >= 33 && n <= 47:
-			num := b[0] - 33
-			c.writeString(specialChars1[num])
-			p.command = append(p.command, specialChars1[num])
-			p.position++
-
-			// Check Special chars 2nd part
-		case n >= 58 && n <= 64:
-			num := b[0] - 58
-			c.writeString(specialChars2[num])
-			p.command = append(p.command, specialChars2[num])
-			p.position++
-
-			// Check Special chars 3rd part
-		case n >= 91 && n <= 96:
-			num := b[0] - 91
-			c.writeString(specialChars3[num])
-			p.command = append(p.command, specialChars3[num])
-			p.position++
-
-			// Check Special chars 4th part
-		case n >= 123 && n <= 126:
-			num := b[0] - 123
-			c.writeString(specialChars4[num])
-			p.command = append(p.command, specialChars4[num])
-			p.position++
-
-		// Check uppercase letters
-		case n >= UPPER_ALPHA && n <= UPPER_OMEGA:
-			num := b[0] - 65
-			c.writeString(strings.ToUpper(alphabet[num]))
-			p.command = append(p.command, strings.ToUpper(alphabet[num]))
-			p.position++
-
-		// Check for lowercase letters
-		case n >= LOW_ALPHA && n <= LOW_OMEGA:
-			num := b[0] - 97
-			c.writeString(alphabet[num])
-			p.command = append(p.command, alphabet[num])
-			p.position++
-
-		// Check for numbers
-		case n >= NUM_0 && n <= NUM_9:
-			num := b[0] - 48
-			c.writeString(fmt.Sprintf("%d", num))
-			p.command = append(p.command, fmt.Sprintf("%d", num))
-			p.position++
-
-		// Enter key
-		case n == ENTER_KEY:
-			if len(p.command) > 0 {
-				p.enterKey(c, eventCh, stopCh)
-			}
-
-		// Space key
-		case n == SPACE_KEY:
-			p.position++
-			if p.position < len(p.command) {
-				p.command = insertInSlice(p.command, p.position-1, " ")
+			// Delete Key
+			if b[2] == 51 && p.position < len(p.command) {
+				p.deletePartofCommand(p.position)
 				p.clear(c)
 				c.writeString(p.getCommandAsString())
-				c.writeGoto(c.h-1, p.position+1)
-
-			} else {
-				c.writeString(" ")
-				p.command = append(p.command, " ")
+				c.writeGoto(c.promptHeight()-1, p.position+1)
 			}
 
-		// Backspace key
-		case n == BACKSPACE_KEY:
-			if p.position > 0 {
-				p.deletePartofCommand(p.position - 1)
-				p.position--
-				p.clear(c)
-				c.writeString(p.getCommandAsString())
-				c.writeGoto(c.h-1, p.position+1)
+			// PageUp and PageDown scroll the message log.
+			if b[2] == 53 || b[2] == 54 {
+				select {
+				case eventCh <- Event{Type: EventScroll, Client: c, Payload: ScrollEvent{Up: b[2] == 53}}:
+				case <-c.quit:
+					return
+				case <-stopCh:
+					return
+				}
 			}
+			continue
+		}
+		p.rollback = 0
+
+		// Pasted text arrives in a single read so every byte is handled.
+		for _, n := range b {
+			switch {
+
+			// Check Special chars 1st part
+			case n >= 33 && n <= 47:
+				num := n - 33
+				c.writeString(specialChars1[num])
+				p.command = append(p.command, specialChars1[num])
+				p.position++
+
+				// Check Special chars 2nd part
+			case n >= 58 && n <= 64:
+				num := n - 58
+				c.writeString(specialChars2[num])
+				p.command = append(p.command, specialChars2[num])
+				p.position++
+
+				// Check Special chars 3rd part
+			case n >= 91 && n <= 96:
+				num := n - 91
+				c.writeString(specialChars3[num])
+				p.command = append(p.command, specialChars3[num])
+				p.position++
+
+				// Check Special chars 4th part
+			case n >= 123 && n <= 126:
+				num := n - 123
+				c.writeString(specialChars4[num])
+				p.command = append(p.command, specialChars4[num])
+				p.position++
+
+			// Check uppercase letters
+			case n >= UPPER_ALPHA && n <= UPPER_OMEGA:
+				num := n - 65
+				c.writeString(strings.ToUpper(alphabet[num]))
+				p.command = append(p.command, strings.ToUpper(alphabet[num]))
+				p.position++
+
+			// Check for lowercase letters
+			case n >= LOW_ALPHA && n <= LOW_OMEGA:
+				num := n - 97
+				c.writeString(alphabet[num])
+				p.command = append(p.command, alphabet[num])
+				p.position++
+
+			// Check for numbers
+			case n >= NUM_0 && n <= NUM_9:
+				num := n - 48
+				c.writeString(fmt.Sprintf("%d", num))
+				p.command = append(p.command, fmt.Sprintf("%d", num))
+				p.position++
+
+			// Enter key
+			case n == ENTER_KEY:
+				if len(p.command) > 0 {
+					p.enterKey(c, eventCh, stopCh)
+				}
 
-		// Delete Key
-		case n == DELETE_KEY && b[2] == 51:
-			if p.position < len(p.command) {
-				p.deletePartofCommand(p.position)
-				p.clear(c)
-				c.writeString(p.getCommandAsString())
-				c.writeGoto(c.h-1, p.position+1)
-			}
+			// Space key
+			case n == SPACE_KEY:
+				p.position++
+				if p.position < len(p.command) {
+					p.command = insertInSlice(p.command, p.position-1, " ")
+					p.clear(c)
+					c.writeString(p.getCommandAsString())
+					c.writeGoto(c.promptHeight()-1, p.position+1)
+
+				} else {
+					c.writeString(" ")
+					p.command = append(p.command, " ")
+				}
 
-		//  Key ] only for debuging purpose.
-		case n == 93:
-			log.Info(fmt.Sprintf("%#v", p.commandHistory))
-			log.Info(fmt.Sprintf("%#v", p.command))
+			// Backspace key
+			case n == BACKSPACE_KEY:
+				if p.position > 0 {
+					p.deletePartofCommand(p.position - 1)
+					p.position--
+					p.clear(c)
+					c.writeString(p.getCommandAsString())
+					c.writeGoto(c.promptHeight()-1, p.position+1)
+				}
+
+			//  Key ] only for debuging purpose.
+			case n == 93:
+				log.Info(fmt.Sprintf("%#v", p.commandHistory))
+				log.Info(fmt.Sprintf("%#v", p.command))
 
+			}
 		}
 	}
 }
@@ -306,16 +322,16 @@ func (p *PromptBar) convertCommadHistoryToArray(command string) {
 
 func (p *PromptBar) fill(c *Client) string {
 	promptBar := ""
-	for i := 0; i < c.w; i++ {
-		promptBar += string(ansi.Attribute(230))
+	for i := 0; i < c.promptWidth(); i++ {
+		promptBar += string(rune(230))
 	}
 	return promptBar
 }
 
 func (p *PromptBar) draw(c *Client) {
-	c.conn.Write([]byte(string(ansi.Goto(uint16(c.h)-2, 1)) + p.fill(c)))
-	c.conn.Write([]byte(string(ansi.Goto(uint16(c.h), 1)) + p.fill(c)))
-	c.conn.Write(ansi.Goto(uint16(c.h)-1, 1))
+	c.conn.Write([]byte(string(ansi.Goto(uint16(c.promptHeight())-2, 1)) + p.fill(c)))
+	c.conn.Write([]byte(string(ansi.Goto(uint16(c.promptHeight()), 1)) + p.fill(c)))
+	c.conn.Write(ansi.Goto(uint16(c.promptHeight())-1, 1))
 }
 
 // Travel backwards through the history of commands
@@ -374,9 +390,11 @@ func (p *PromptBar) enterKey(c *Client, eventCh chan Event, stopCh <-chan struct
 	c.conn.Write(ansi.CursorShow)
 
 	p.draw(c)
-	event := Event{Client: c, EventType: p.getCommandAsString()}
+	event := Event{Type: EventCommand, Client: c, Payload: CommandEvent{Line: p.getCommandAsString()}}
 	select {
 	case eventCh <- event:
+	case <-c.quit:
+		return
 	case <-stopCh:
 		return
 	}
@@ -410,5 +428,5 @@ func insertInSlice(original []string, position int, value string) []string {
 
 func (p *PromptBar) clear(c *Client) {
 	c.conn.Write(ansi.EraseLine)
-	c.conn.Write(ansi.Goto(uint16(c.h)-1, 1))
+	c.conn.Write(ansi.Goto(uint16(c.promptHeight())-1, 1))
 }
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"sort"
+	"time"
+)
+
+// timerFunc is what a timer runs when it is due. now is the time of the tick
+// that runs it.
+type timerFunc func(now time.Time)
+
+// timer is something the scheduler runs later, once or over and over.
+type timer struct {
+	id        int
+	name      string
+	at        time.Time
+	every     time.Duration
+	run       timerFunc
+	cancelled bool
+}
+
+// cancel stops the timer. A timer can cancel itself while it runs.
+func (t *timer) cancel() {
+	t.cancelled = true
+}
+
+// scheduler runs timers on the world tick. It belongs to God: timers are
+// added, cancelled and run from the God goroutine only, so they can change
+// the game state freely. Timers are only as precise as the tick.
+type scheduler struct {
+	now    time.Time
+	nextID int
+	timers []*timer
+}
+
+// newScheduler returns a scheduler whose clock starts at now.
+func newScheduler(now time.Time) *scheduler {
+	return &scheduler{now: now}
+}
+
+// after runs fn once, d after the last tick.
+func (sc *scheduler) after(name string, d time.Duration, fn timerFunc) *timer {
+	return sc.add(&timer{name: name, at: sc.now.Add(d), run: fn})
+}
+
+// every runs fn every d, starting d after the last tick. A timer that falls
+// behind runs once per tick rather than catching up.
+func (sc *scheduler) every(name string, d time.Duration, fn timerFunc) *timer {
+	return sc.add(&timer{name: name, at: sc.now.Add(d), every: d, run: fn})
+}
+
+func (sc *scheduler) add(t *timer) *timer {
+	sc.nextID++
+	t.id = sc.nextID
+	sc.timers = append(sc.timers, t)
+	return t
+}
+
+// run runs the timers that are due at now, earliest first and in the order
+// they were added when due at the same time. Timers added while running wait
+// for the next tick. It returns how many timers ran.
+func (sc *scheduler) run(now time.Time) int {
+	sc.now = now
+
+	var due []*timer
+	for _, t := range sc.timers {
+		if !t.cancelled && !t.at.After(now) {
+			due = append(due, t)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].at.Equal(due[j].at) {
+			return due[i].at.Before(due[j].at)
+		}
+		return due[i].id < due[j].id
+	})
+
+	ran := 0
+	for _, t := range due {
+		if t.cancelled {
+			continue
+		}
+		t.run(now)
+		ran++
+		if t.every <= 0 {
+			t.cancelled = true
+			continue
+		}
+		for !t.at.After(now) {
+			t.at = t.at.Add(t.every)
+		}
+	}
+
+	timers := sc.timers[:0]
+	for _, t := range sc.timers {
+		if !t.cancelled {
+			timers = append(timers, t)
+		}
+	}
+	sc.timers = timers
+	return ran
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	start := time.Now()
+	sc := newScheduler(start)
+
+	var ran []string
+	record := func(name string) timerFunc {
+		return func(time.Time) { ran = append(ran, name) }
+	}
+	sc.every("regen", 2*time.Second, record("regen"))
+	sc.after("reset", 3*time.Second, record("reset"))
+	round := sc.every("round", time.Second, record("round"))
+	sc.after("late", 3*time.Second, func(time.Time) {
+		ran = append(ran, "late")
+		round.cancel()
+		sc.after("added", 0, record("added"))
+	})
+
+	tests := []struct {
+		name  string
+		after time.Duration
+
+		expected []string
+	}{
+		{name: "too soon", after: time.Second / 2},
+		{name: "one second", after: time.Second, expected: []string{"round"}},
+		{name: "two seconds", after: 2 * time.Second, expected: []string{"regen", "round"}},
+		{name: "three seconds", after: 3 * time.Second, expected: []string{"reset", "round", "late"}},
+		{name: "added while running", after: 3 * time.Second, expected: []string{"added"}},
+		{name: "missed ticks", after: 10 * time.Second, expected: []string{"regen"}},
+		{name: "back on schedule", after: 11 * time.Second},
+		{name: "twelve seconds", after: 12 * time.Second, expected: []string{"regen"}},
+	}
+
+	for _, test := range tests {
+		ran = nil
+		if n := sc.run(start.Add(test.after)); n != len(test.expected) || !reflect.DeepEqual(ran, test.expected) {
+			t.Errorf("%s: expected %q to run, got %d: %q", test.name, test.expected, n, ran)
+		}
+	}
+	if len(sc.timers) != 1 {
+		t.Errorf("expected only the regen timer to be left, got %d timers", len(sc.timers))
+	}
+}
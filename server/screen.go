@@ -1,45 +1,243 @@
 package server
 
-import "bytes"
+import (
+	"bytes"
+	"fmt"
 
+	"github.com/gothyra/thyra/area"
+	"github.com/gothyra/thyra/game"
+
+	"github.com/jpillora/ansi"
+)
+
+// diffGap is how many unchanged cells are rewritten rather than moving the
+// cursor over them, which takes about as many bytes.
+const diffGap = 6
+
+// Screen is double buffered: screenRunes and screenAttrs are the frame being
+// drawn, sentRunes and sentAttrs the last frame sent to the client, so that
+// only the cells that changed need to be sent.
 type Screen struct {
-	width          int
-	height         int
-	exitCanvas     []rune
-	messagesCanvas []rune
-	mapCanvas      [][]rune
-	introCanvas    [][]rune
-	screenRunes    [][]rune
-	screenColors   [][]ID // the player's view of the screen
+	width       int
+	height      int
+	exitCanvas  []rune
+	mapCanvas   [][]rune
+	mapAttrs    [][]Attr
+	introCanvas [][]rune
+	sheetCanvas [][]rune
+	screenRunes [][]rune
+	screenAttrs [][]Attr // the player's view of the screen
+	sentRunes   [][]rune
+	sentAttrs   [][]Attr
 }
 
 // Initialize new Screen
 func NewScreen(width, height int) *Screen {
 	screenRunes := make([][]rune, height)
-	screenColors := make([][]ID, height)
-	for h := 0; h < height-3; h++ {
+	screenAttrs := make([][]Attr, height)
+	for h := 0; h < height; h++ {
+		// The input line belongs to the prompt bar.
+		if h == height-2 {
+			continue
+		}
 
 		screenRunes[h] = make([]rune, width)
-		screenColors[h] = make([]ID, width)
+		screenAttrs[h] = make([]Attr, width)
 
 		for w := 0; w < width; w++ {
 
 			screenRunes[h][w] = ' '
-			screenColors[h][w] = ID(255)
 		}
 	}
 
 	return &Screen{
-		width:          width,
-		height:         height,
-		exitCanvas:     make([]rune, 0),
-		messagesCanvas: make([]rune, 0),
-		mapCanvas:      make([][]rune, 0),
-		introCanvas:    make([][]rune, 0),
-		screenRunes:    screenRunes,
-		screenColors:   screenColors,
+		width:       width,
+		height:      height,
+		exitCanvas:  make([]rune, 0),
+		mapCanvas:   make([][]rune, 0),
+		introCanvas: make([][]rune, 0),
+		sheetCanvas: make([][]rune, 0),
+		screenRunes: screenRunes,
+		screenAttrs: screenAttrs,
+	}
+
+}
+
+// resize makes the screen fit the terminal. The next render redraws
+// everything.
+func (scr *Screen) resize(width, height int) {
+	if scr.width == width && scr.height == height && scr.screenRunes != nil {
+		return
+	}
+	*scr = *NewScreen(width, height)
+}
+
+// clear empties the canvases and the frame being drawn.
+func (scr *Screen) clear() {
+	scr.exitCanvas = make([]rune, 0)
+	scr.mapCanvas = make([][]rune, 0)
+	scr.mapAttrs = make([][]Attr, 0)
+	scr.introCanvas = make([][]rune, 0)
+	scr.sheetCanvas = make([][]rune, 0)
+	for y, row := range scr.screenRunes {
+		for x := range row {
+			row[x] = ' '
+			scr.screenAttrs[y][x] = Attr{}
+		}
+	}
+}
+
+// setMap fills the map canvas with the given tiles.
+func (scr *Screen) setMap(tiles [][]area.Tile) {
+	for _, row := range tiles {
+		runes := make([]rune, len(row))
+		attrs := make([]Attr, len(row))
+		for x, tile := range row {
+			runes[x] = tile.Glyph()
+			attrs[x] = tileAttrs[tile]
+		}
+		scr.mapCanvas = append(scr.mapCanvas, runes)
+		scr.mapAttrs = append(scr.mapAttrs, attrs)
+	}
+}
+
+// setSheet fills the character sheet canvas with the player's stats.
+func (scr *Screen) setSheet(p *area.Player) {
+	lines := []string{
+		p.Nickname,
+		fmt.Sprintf("Level %d %s", p.Level, p.Class),
+		"",
+		fmt.Sprintf("HP  %d/%d", p.HP-p.Damage, p.HP),
+		fmt.Sprintf("AC  %d", p.AC),
+		"",
+		fmt.Sprintf("STR %d", p.STR),
+		fmt.Sprintf("DEX %d", p.DEX),
+		fmt.Sprintf("CON %d", p.CON),
+		fmt.Sprintf("INT %d", p.INT),
+		fmt.Sprintf("WIS %d", p.WIS),
+		fmt.Sprintf("CHA %d", p.CHA),
+		"",
+		p.Weapon,
+		game.WeaponOf(&p.PC).String(),
+		p.Armor,
 	}
+	for _, line := range lines {
+		scr.sheetCanvas = append(scr.sheetCanvas, []rune(line))
+	}
+}
+
+// setCell sets a cell of the frame being drawn. Cells off the frame are
+// ignored.
+func (scr *Screen) setCell(y, x int, r rune, attr Attr) {
+	if y < 0 || y >= len(scr.screenRunes) || x < 0 || x >= len(scr.screenRunes[y]) {
+		return
+	}
+	scr.screenRunes[y][x] = r
+	scr.screenAttrs[y][x] = attr
+}
 
+// tileAttrs are the colors of the map tiles.
+var tileAttrs = map[area.Tile]Attr{
+	area.TileWall:   {Fg: RGB(138, 118, 96)},
+	area.TileFloor:  {Fg: BrightBlack},
+	area.TileDoor:   {Fg: RGB(215, 175, 0), Style: StyleBold},
+	area.TilePlayer: {Fg: BrightGreen, Style: StyleBold},
+	area.TileOther:  {Fg: BrightRed, Style: StyleBold},
+	area.TileStairs: {Fg: RGB(215, 175, 0)},
+}
+
+// invalidate forgets what the client shows, so that the next render redraws
+// everything.
+func (scr *Screen) invalidate() {
+	scr.sentRunes = nil
+	scr.sentAttrs = nil
+}
+
+// render returns the output that brings the client from the last frame sent
+// to the current one, and remembers the current one as sent.
+func (scr *Screen) render(p colorProfile) []byte {
+	var out []byte
+	if scr.sentRunes == nil {
+		out = scr.full(p)
+		scr.sentRunes = make([][]rune, len(scr.screenRunes))
+		scr.sentAttrs = make([][]Attr, len(scr.screenAttrs))
+	} else {
+		out = scr.diff(p)
+	}
+	for y, row := range scr.screenRunes {
+		scr.sentRunes[y] = append(scr.sentRunes[y][:0], row...)
+		scr.sentAttrs[y] = append(scr.sentAttrs[y][:0], scr.screenAttrs[y]...)
+	}
+	return out
+}
+
+// full returns the output that draws the whole current frame.
+func (scr *Screen) full(p colorProfile) []byte {
+	w := &cellWriter{profile: p}
+	for y, row := range scr.screenRunes {
+		if len(row) > 0 {
+			w.run(y, 0, row, scr.screenAttrs[y])
+		}
+	}
+	return w.done()
+}
+
+// changed reports whether the cell at x, y differs from the one sent.
+func (scr *Screen) changed(y, x int) bool {
+	sent := scr.sentRunes[y]
+	return x >= len(sent) || scr.screenRunes[y][x] != sent[x] || scr.screenAttrs[y][x] != scr.sentAttrs[y][x]
+}
+
+// diff returns the output that draws the cells that changed since the last
+// frame sent. Changes close to each other are sent as a single run.
+func (scr *Screen) diff(p colorProfile) []byte {
+	w := &cellWriter{profile: p}
+	for y, row := range scr.screenRunes {
+		for x := 0; x < len(row); x++ {
+			if !scr.changed(y, x) {
+				continue
+			}
+			// Extend the run up to the last change followed by diffGap
+			// unchanged cells.
+			start, end := x, x+1
+			for next := end; next < len(row) && next-end < diffGap; next++ {
+				if scr.changed(y, next) {
+					end = next + 1
+				}
+			}
+			w.run(y, start, row[start:end], scr.screenAttrs[y][start:end])
+			x = end - 1
+		}
+	}
+	return w.done()
+}
+
+// cellWriter turns runs of cells into output, switching attributes only when
+// they change. Output starts and ends with the default attributes.
+type cellWriter struct {
+	profile colorProfile
+	out     []byte
+	attr    Attr
+}
+
+// run draws the cells starting at row y, column x.
+func (w *cellWriter) run(y, x int, runes []rune, attrs []Attr) {
+	w.out = append(w.out, ansi.Goto(uint16(y+1), uint16(x+1))...)
+	for i, r := range runes {
+		if attr := w.profile.visible(attrs[i]); attr != w.attr {
+			w.out = append(w.out, w.profile.sgr(attr)...)
+			w.attr = attr
+		}
+		w.out = append(w.out, string(r)...)
+	}
+}
+
+// done returns the output, leaving the terminal with the default attributes.
+func (w *cellWriter) done() []byte {
+	if w.attr != (Attr{}) {
+		w.out = append(w.out, w.profile.sgr(Attr{})...)
+	}
+	return w.out
 }
 
 // TODO : Check for offsets. Add limitation to all Canvas
@@ -48,20 +246,6 @@ func (scr *Screen) updateScreenRunes(frame string, bufToUpdate bytes.Buffer) {
 	buf := bytes.NewBuffer(bufToUpdate.Bytes())
 
 	switch frame {
-	case "map":
-		for {
-			char, _, err := buf.ReadRune()
-			if err != nil {
-				break
-			}
-			if char == '\n' {
-				scr.mapCanvas = append(scr.mapCanvas, runes)
-				runes = []rune{}
-			} else {
-				runes = append(runes, char)
-			}
-		}
-
 	case "exits":
 		for {
 			char, _, err := buf.ReadRune()
@@ -89,19 +273,5 @@ func (scr *Screen) updateScreenRunes(frame string, bufToUpdate bytes.Buffer) {
 				runes = append(runes, char)
 			}
 		}
-
-	case "message":
-		for {
-			char, _, err := buf.ReadRune()
-			if err != nil {
-				break
-			}
-			if char == '\n' {
-				scr.messagesCanvas = runes
-				runes = []rune{}
-			} else {
-				runes = append(runes, char)
-			}
-		}
 	}
 }
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/jpillora/ansi"
+)
+
+func TestScreenRender(t *testing.T) {
+	scr := NewScreen(12, 5)
+	copy(scr.screenRunes[0], []rune("hello"))
+
+	full := scr.render(profileMono)
+	if expected := string(ansi.Goto(1, 1)) + "hello       " + string(ansi.Goto(2, 1)) + "            " +
+		string(ansi.Goto(3, 1)) + "            " + string(ansi.Goto(5, 1)) + "            "; string(full) != expected {
+		t.Fatalf("expected the first render to draw everything: %q\ngot: %q", expected, full)
+	}
+
+	tests := []struct {
+		name string
+
+		row, col int
+		text     string
+
+		expected string
+	}{
+		{
+			name:     "nothing changed",
+			row:      0,
+			col:      0,
+			text:     "hello",
+			expected: "",
+		},
+		{
+			name:     "single cell",
+			row:      1,
+			col:      3,
+			text:     "x",
+			expected: string(ansi.Goto(2, 4)) + "x",
+		},
+		{
+			name:     "changes close together form one run",
+			row:      0,
+			col:      0,
+			text:     "jello w",
+			expected: string(ansi.Goto(1, 1)) + "jello w",
+		},
+		{
+			name:     "changes far apart form two runs",
+			row:      0,
+			col:      0,
+			text:     "Jello w    X",
+			expected: string(ansi.Goto(1, 1)) + "J" + string(ansi.Goto(1, 12)) + "X",
+		},
+	}
+
+	for _, test := range tests {
+		copy(scr.screenRunes[test.row][test.col:], []rune(test.text))
+		if got := string(scr.render(profileMono)); got != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, got)
+		}
+	}
+
+	scr.screenAttrs[1][0] = Attr{Fg: BrightGreen, Style: StyleBold}
+	if got, expected := string(scr.render(profile16)), string(ansi.Goto(2, 1))+"\x1b[0;1;92m \x1b[0m"; got != expected {
+		t.Errorf("color change: expected %q, got %q", expected, got)
+	}
+
+	scr.invalidate()
+	if got := scr.render(profileMono); string(got) != string(scr.full(profileMono)) {
+		t.Errorf("expected a full redraw after invalidate, got %q", got)
+	}
+}
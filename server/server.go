@@ -2,9 +2,7 @@ package server
 
 import (
 	"bytes"
-	"crypto/md5"
 	"encoding/binary"
-	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
@@ -16,6 +14,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gothyra/thyra/area"
 	"github.com/gothyra/thyra/game"
@@ -34,20 +33,29 @@ var (
 
 type Server struct {
 	sync.RWMutex
-	port          int
+	config        Config
 	addresses     string
-	idPool        <-chan ID
+	idPool        chan ID
 	logf          func(format string, args ...interface{})
-	privateKey    ssh.Signer
+	hostKeys      *hostKeys
+	db            *database
+	store         PlayerStore
+	autosave      *autosave
 	onlineClients map[string]*Client
 	Players       map[string]area.Player
+	commands      *commandRegistry
+	scheduler     *scheduler
+	world         *world
+	narrator      game.Narrator
 	Events        chan Event
 	Areas         map[string]area.Area
 	staticDir     string
 }
 
-// TODO: Use a .thyra.toml file for client configuration.
-func NewServer(port int) (*Server, error) {
+// NewServer creates a server configured from configPath, which defaults to
+// server.toml in the static directory. Environment variables and the given
+// overrides take precedence over the file.
+func NewServer(configPath string, overrides Config) (*Server, error) {
 	// Environment variables
 	staticDir := os.Getenv("THYRA_STATIC")
 	if len(staticDir) == 0 {
@@ -57,15 +65,26 @@ func NewServer(port int) (*Server, error) {
 	}
 	log.Info(fmt.Sprintf("Using %s for static content", staticDir))
 
-	idPool := make(chan ID, 100)
-	for id := 1; id <= 100; id++ {
+	if len(configPath) == 0 {
+		configPath = filepath.Join(staticDir, "server.toml")
+	}
+	config, err := buildConfig(configPath, overrides)
+	if err != nil {
+		return nil, err
+	}
+	log.Info(fmt.Sprintf("Using configuration: %+v", config))
+
+	idPool := make(chan ID, config.MaxPlayers)
+	for id := 1; id <= config.MaxPlayers; id++ {
 		idPool <- ID(id)
 	}
 
 	s := &Server{
-		port:          port,
+		config:        config,
 		idPool:        idPool,
 		onlineClients: make(map[string]*Client),
+		commands:      newCommandRegistry(gameCommands...),
+		scheduler:     newScheduler(time.Now()),
 		Events:        make(chan Event),
 		Areas:         make(map[string]area.Area),
 		staticDir:     staticDir,
@@ -75,14 +94,21 @@ func NewServer(port int) (*Server, error) {
 	if err := s.loadAreas(); err != nil {
 		os.Exit(1)
 	}
+	s.world = newWorld(s.Areas, time.Now().UnixNano())
+	if s.narrator, err = loadNarration(config.Narration); err != nil {
+		return nil, err
+	}
 
-	db, err := newDatabase(filepath.Join(os.TempDir(), "thyra.db"), true)
+	db, err := newDatabase(config.DBPath, config.ResetDB)
 	if err != nil {
-		log.Error(err.Error())
-		os.Exit(1)
+		return nil, err
 	}
+	s.db = db
+	s.store = newBoltPlayerStore(db)
+	s.autosave = newAutosave(s.store)
 
-	if err := db.getPrivateKey(s); err != nil {
+	if s.hostKeys, err = loadHostKeys(db, config.HostKeyFiles, config.HostKeyGrace.Duration); err != nil {
+		db.Close()
 		return nil, err
 	}
 	if addrs, err := net.InterfaceAddrs(); err == nil {
@@ -90,7 +116,7 @@ func NewServer(port int) (*Server, error) {
 		for _, a := range addrs {
 			ipv4 := matchip.FindString(a.String())
 			if ipv4 != "" {
-				joins = append(joins, fmt.Sprintf(" ssh %s -p %d", ipv4, s.port))
+				joins = append(joins, fmt.Sprintf(" ssh %s -p %d", ipv4, s.config.Port))
 			}
 		}
 		s.addresses = strings.Join(joins, "\n")
@@ -98,32 +124,52 @@ func NewServer(port int) (*Server, error) {
 	return s, nil
 }
 
+// Config returns the configuration the server is running with.
+func (s *Server) Config() Config {
+	return s.config
+}
+
 func (s *Server) StartServer() {
-	// bind to provided port
-	server, err := net.ListenTCP("tcp4", &net.TCPAddr{Port: s.port})
+	// bind to the configured address
+	addr := net.JoinHostPort(s.config.Host, strconv.Itoa(s.config.Port))
+	tcpAddr, err := net.ResolveTCPAddr("tcp4", addr)
 	if err != nil {
-		log.Info(fmt.Sprintf("%v", err))
+		log.Error(err.Error())
+		return
+	}
+	server, err := net.ListenTCP("tcp4", tcpAddr)
+	if err != nil {
+		log.Error(err.Error())
+		return
 	}
-	log.Info(fmt.Sprintf("Listening for incoming connections on localhost:%d", s.port))
+	log.Info(fmt.Sprintf("Listening for incoming connections on %s", addr))
 
 	// Channel for gracefully shutting down all the rest of the threads.
 	stopCh := make(chan struct{})
 	wg := &sync.WaitGroup{}
 
-	// God has all the server-side logic.
-	wg.Add(1)
-	go s.God(stopCh, wg)
+	// God has all the server-side logic. It is waited for separately so
+	// that players are only saved once nothing changes them anymore.
+	godWg := &sync.WaitGroup{}
+	godWg.Add(2)
+	go s.God(stopCh, godWg)
+	go s.ticker(stopCh, godWg)
 
 	// accept connections
-	// wg.Add(1)
+	stopAccepting := make(chan struct{})
+	wg.Add(1)
 	go func() {
-		// defer wg.Done()
+		defer wg.Done()
 
 		for {
-			// TODO: Timeout after some time to unblock the loop occasionally
-			// and check for graceful termination.
 			tcpConn, err := server.AcceptTCP()
 			if err != nil {
+				select {
+				case <-stopAccepting:
+					log.Info("Stopped accepting connections.")
+					return
+				default:
+				}
 				log.Warn(err.Error())
 				continue
 			}
@@ -134,34 +180,25 @@ func (s *Server) StartServer() {
 
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, os.Interrupt, os.Kill)
-	select {
-	case <-signals:
-		log.Warn("Server is terminating...")
-		close(stopCh)
-	}
+	<-signals
+	log.Warn("Server is terminating...")
+
+	close(stopAccepting)
+	server.Close()
+	s.countdown(signals)
+	s.shutdown(stopCh, godWg, wg)
 
-	wg.Wait()
+	if err := s.db.Close(); err != nil {
+		log.Error(fmt.Sprintf("Cannot close database: %v", err))
+	}
 	log.Warn("Server shutdown.")
 }
 
 func (s *Server) handle(tcpConn *net.TCPConn, stopCh <-chan struct{}, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	// TODO: Revisit everything ssh-related.
-	//extract these from connection
-	var sshName, hash string
 	// perform handshake
-	config := &ssh.ServerConfig{
-		PublicKeyCallback: func(conn ssh.ConnMetadata, publicKey ssh.PublicKey) (*ssh.Permissions, error) {
-			sshName = conn.User()
-			if publicKey != nil {
-				m := md5.Sum(publicKey.Marshal())
-				hash = hex.EncodeToString(m[:])
-			}
-			return nil, nil
-		},
-	}
-	config.AddHostKey(s.privateKey)
+	config, kex := s.sshConfig()
 	sshConn, chans, globalReqs, err := ssh.NewServerConn(tcpConn, config)
 	if err != nil {
 		log.Warn(fmt.Sprintf("new connection handshake failed (%s)", err))
@@ -169,15 +206,13 @@ func (s *Server) handle(tcpConn *net.TCPConn, stopCh <-chan struct{}, wg *sync.W
 	}
 	defer sshConn.Close()
 
-	// global requests must be serviced - discard
-	go ssh.DiscardRequests(globalReqs)
-	// protect against XTR (cross terminal renderering) attacks
-	name := filtername.ReplaceAllString(sshName, "")
-	// trim name
-	maxlen := 100
-	if len(name) > maxlen {
-		name = string([]rune(name)[:maxlen])
-	}
+	// global requests must be serviced - answer host key proofs, reject rest
+	go s.hostKeys.serveRequests(sshConn, globalReqs, kex)
+	s.hostKeys.advertise(sshConn)
+	sshName := sshConn.User()
+	name := playerName(sshName)
+	ext := sshConn.Permissions.Extensions
+	hash := ext[extFingerprint]
 	// get the first channel
 	var c ssh.NewChannel
 	select {
@@ -218,6 +253,9 @@ func (s *Server) handle(tcpConn *net.TCPConn, stopCh <-chan struct{}, wg *sync.W
 	if name == "" {
 		name = fmt.Sprintf("player-%d", id)
 	}
+	if ext[extMethod] == methodGuest {
+		name = fmt.Sprintf("%s-%d", s.config.AnonymousUser, id)
+	}
 	// if user has no public key for some strange reason, use their ip as their unique id
 	if hash == "" {
 		if ip, _, err := net.SplitHostPort(tcpConn.RemoteAddr().String()); err == nil {
@@ -226,27 +264,58 @@ func (s *Server) handle(tcpConn *net.TCPConn, stopCh <-chan struct{}, wg *sync.W
 	}
 	log.Info(fmt.Sprintf("Creating new client %q: id: %d, hash: %s", name, id, hash))
 
-	player, err := s.createOrLoadPlayer(name)
+	player, err := s.createOrLoadPlayer(name, ext)
 	if err != nil {
 		log.Warn(fmt.Sprintf("Cannot load player %q: %v", name, err))
+		conn.Write([]byte(fmt.Sprintf("Cannot log in as %s: %v\r\n", name, err)))
+		s.idPool <- id
 		return
 	}
 
-	client := NewClient(id, sshName, name, hash, conn, player)
-	s.clientLoggedIn(client)
+	out := newOutbox(conn, s.config.OutputQueue, s.config.OutputOverflow)
+	client := NewClient(id, sshName, name, hash, sshConn, out, player)
+	client.messages = newMessageLog(s.config.MessageHistory)
+	client.chat = &chatLimiter{}
 
 	// Client threads that handle all the output from the server are started here.
 	wg.Add(1)
 	client.prepareClient(s.Events, stopCh, wg)
 
+	// God decides whether the client joins, takes over or is rejected.
+	select {
+	case s.Events <- Event{Type: EventConnect, Client: client}:
+	case <-stopCh:
+		s.idPool <- id
+		return
+	}
+
+	// The terminal type decides the colors the client gets. God keeps the
+	// profile, as it draws the screen.
+	var term, colorTerm string
+	terminal := func() bool {
+		select {
+		case s.Events <- Event{Type: EventTerminal, Client: client, Payload: TerminalEvent{Term: term, ColorTerm: colorTerm}}:
+			return true
+		case <-client.quit:
+		case <-stopCh:
+		}
+		log.Info(fmt.Sprintf("[%s] handle exiting.", client.Name))
+		return false
+	}
 	for {
 		select {
 		case <-stopCh:
 			log.Info(fmt.Sprintf("[%s] handle exiting.", client.Name))
 			return
-		case r := <-chanReqs:
-			if r == nil {
-				continue
+		case <-client.quit:
+			log.Info(fmt.Sprintf("[%s] handle exiting.", client.Name))
+			return
+		case r, open := <-chanReqs:
+			if !open {
+				// The client closed the session. Closing the connection
+				// makes receiveActions report the disconnect to God.
+				log.Info(fmt.Sprintf("[%s] session closed.", client.Name))
+				return
 			}
 			log.Info(fmt.Sprintf("[%s] request type: %s", client.Name, r.Type))
 
@@ -261,11 +330,30 @@ func (s *Server) handle(tcpConn *net.TCPConn, stopCh <-chan struct{}, wg *sync.W
 			case "pty-req":
 				// Responding 'ok' here will let the client
 				// know we have a pty ready for input
+				var pty ptyRequest
+				if err := ssh.Unmarshal(r.Payload, &pty); err != nil {
+					log.Debug(fmt.Sprintf("[%s] bad pty-req: %v", client.Name, err))
+					break
+				}
 				ok = true
-				strlen := r.Payload[3]
-				client.resizes <- parseDims(r.Payload[strlen+4:])
+				term = pty.Term
+				if !terminal() {
+					return
+				}
+				client.resize(resize{width: pty.Cols, height: pty.Rows})
+			case "env":
+				// Only COLORTERM is of interest, to tell truecolor
+				// terminals apart.
+				var env envRequest
+				if err := ssh.Unmarshal(r.Payload, &env); err == nil && env.Name == "COLORTERM" {
+					ok = true
+					colorTerm = env.Value
+					if !terminal() {
+						return
+					}
+				}
 			case "window-change":
-				client.resizes <- parseDims(r.Payload)
+				client.resize(parseDims(r.Payload))
 				continue // no response
 			}
 			log.Info(fmt.Sprintf("[%s] replying ok to a %q request", client.Name, r.Type))
@@ -274,6 +362,19 @@ func (s *Server) handle(tcpConn *net.TCPConn, stopCh <-chan struct{}, wg *sync.W
 	}
 }
 
+// ptyRequest is the payload of a "pty-req" request (RFC 4254, 6.2).
+type ptyRequest struct {
+	Term          string
+	Cols, Rows    uint32
+	Width, Height uint32
+	Modes         string
+}
+
+// envRequest is the payload of an "env" request (RFC 4254, 6.4).
+type envRequest struct {
+	Name, Value string
+}
+
 // parseDims extracts two uint32s from the provided buffer.
 func parseDims(b []byte) resize {
 	if len(b) < 8 {
@@ -303,20 +404,25 @@ func (s *Server) OnlineClients() []Client {
 	return online
 }
 
-// clientLoggedIn stores the logged in player into an internal cache that holds
-// all online players.
-func (s *Server) clientLoggedIn(client *Client) {
-	s.Lock()
-	s.onlineClients[client.Name] = client
-	s.Unlock()
+// isOnline reports whether the client is the one playing its player.
+func (s *Server) isOnline(client *Client) bool {
+	s.RLock()
+	defer s.RUnlock()
+	return s.onlineClients[client.Name] == client
 }
 
 // clientLoggedOut removes the logged out player from the internal cache that
-// holds all online players.
-func (s *Server) clientLoggedOut(name string) {
+// holds all online players. It reports false if the client was not online.
+func (s *Server) clientLoggedOut(client *Client) bool {
 	s.Lock()
-	delete(s.onlineClients, name)
-	s.Unlock()
+	defer s.Unlock()
+
+	if s.onlineClients[client.Name] != client {
+		return false
+	}
+	delete(s.onlineClients, client.Name)
+	delete(s.Players, client.Player.Nickname)
+	return true
 }
 
 // loadAreas loads all the areas from the static directory into memory.
@@ -342,6 +448,7 @@ func (s *Server) loadAreas() error {
 			return err
 		}
 
+		checkExits(path, area)
 		log.Info(fmt.Sprintf("Loaded area %q", area.Name))
 		// TODO: Lock
 		s.Areas[area.Name] = area
@@ -352,6 +459,36 @@ func (s *Server) loadAreas() error {
 	return filepath.Walk(s.staticDir+"/areas/", areaWalker)
 }
 
+// loadNarration reads the combat narration templates from path, or returns
+// the default ones when path is empty.
+func loadNarration(path string) (game.Narrator, error) {
+	set := game.DefaultNarration
+	if path != "" {
+		set = game.NarrationSet{}
+		if _, err := toml.DecodeFile(path, &set); err != nil {
+			return nil, fmt.Errorf("%s could not be unmarshaled: %v", path, err)
+		}
+	}
+	narrator, err := game.NewTemplateNarrator(set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid narration in %s: %v", path, err)
+	}
+	return narrator, nil
+}
+
+// checkExits warns about exits with a direction players cannot take.
+func checkExits(path string, a area.Area) {
+	for _, room := range a.Rooms {
+		for _, cube := range room.Cubes {
+			for _, exit := range cube.Exits {
+				if _, ok := area.ParseDirection(exit.Direction); !ok && exit.Direction != "" {
+					log.Warn(fmt.Sprintf("%s: cube %s of room %s has an exit with unknown direction %q", path, cube.ID, room.Name, exit.Direction))
+				}
+			}
+		}
+	}
+}
+
 // OnlineClientsGetByRoom returns all the online players in the given room.
 func (s *Server) OnlineClientsGetByRoom(area, room string) []Client {
 	clients := s.OnlineClients()
@@ -450,14 +587,6 @@ func (s *Server) CreateRoom(areaName, room string) [][]area.Cube {
 	return maparray
 }
 
-// getPlayerFileName constructs the path for the player file.
-func (s *Server) getPlayerFileName(playerName string) (string, error) {
-	if !isValidUsername(playerName) {
-		return "", fmt.Errorf("invalid username: %s", playerName)
-	}
-	return s.staticDir + "/player/" + playerName + ".toml", nil
-}
-
 // isValidUsername checks if the given player name is a valid one.
 // TODO: Revisit what we want for a valid username.
 func isValidUsername(playerName string) bool {
@@ -471,36 +600,46 @@ func isValidUsername(playerName string) bool {
 	return true
 }
 
-// createOrLoadPlayer creates or loads a player with the given nickname.
-func (s *Server) createOrLoadPlayer(nick string) (*area.Player, error) {
+// createOrLoadPlayer creates or loads a player with the given nickname and
+// checks it against the credentials accepted during the handshake. Players
+// without any credentials get them registered and are saved right away.
+// Guests always get a new player that is never saved.
+func (s *Server) createOrLoadPlayer(nick string, ext map[string]string) (*area.Player, error) {
 	s.Lock()
 	defer s.Unlock()
 
-	playerFileName, err := s.getPlayerFileName(nick)
-	if err != nil {
-		// Invalid username.
-		return nil, err
+	if !isValidUsername(nick) {
+		return nil, fmt.Errorf("invalid username: %s", nick)
+	}
+
+	if ext[extMethod] == methodGuest {
+		log.Info(fmt.Sprintf("Creating guest player %q.", nick))
+		player := s.newPlayer(nick)
+		player.Guest = true
+		s.Players[player.Nickname] = player
+		return &player, nil
 	}
 
 	// If the player already exists, load it.
 	var player area.Player
-	if _, err := os.Stat(playerFileName); err != nil {
+	stored, err := s.store.Load(nick)
+	switch {
+	case err == ErrPlayerNotFound:
 		log.Info(fmt.Sprintf("Creating new player %q.", nick))
-		// TODO: Create a generator for players.
-		player = area.Player{
-			Nickname: nick,
-			PC:       *game.NewPC(),
-			Area:     "City",
-			Room:     "Inn",
-			Position: "1",
-		}
-	} else {
+		player = s.newPlayer(nick)
+	case err != nil:
+		return nil, err
+	default:
 		log.Info(fmt.Sprintf("Player %q already exists.", nick))
-		fileContent, err := ioutil.ReadFile(playerFileName)
-		if err != nil {
-			return nil, err
-		}
-		if _, err := toml.Decode(string(fileContent), &player); err != nil {
+		player = *stored
+	}
+
+	registered, err := bindCredentials(&player, ext)
+	if err != nil {
+		return nil, err
+	}
+	if registered {
+		if err := s.savePlayer(player); err != nil {
 			return nil, err
 		}
 	}
@@ -509,21 +648,34 @@ func (s *Server) createOrLoadPlayer(nick string) (*area.Player, error) {
 	return &player, nil
 }
 
-// savePlayer saves the player back to the static directory.
-// TODO: Add an autosave mechanism instead of saving Players
-// once they quit.
-func (s *Server) savePlayer(player area.Player) error {
-	data := &bytes.Buffer{}
-	encoder := toml.NewEncoder(data)
-	err := encoder.Encode(player)
-	if err != nil {
-		return err
+// newPlayer creates a player at the configured starting position.
+// TODO: Create a generator for players.
+func (s *Server) newPlayer(nick string) area.Player {
+	return area.Player{
+		Nickname: nick,
+		PC:       *game.NewPC(game.NewDice(time.Now().UnixNano())),
+		Area:     s.config.StartArea,
+		Room:     s.config.StartRoom,
+		Position: s.config.StartPosition,
 	}
+}
 
-	playerFileName, err := s.getPlayerFileName(player.Nickname)
-	if err != nil {
-		return err
+// savePlayer saves the player into the player store right away. Guests are
+// not saved. God uses the autosave instead.
+func (s *Server) savePlayer(player area.Player) error {
+	if player.Guest {
+		return nil
 	}
+	return s.store.Save(player)
+}
+
+// ImportPlayers copies the TOML player files found in dir into the player
+// store. It is meant to be run once when migrating from file based players.
+func (s *Server) ImportPlayers(dir string) (int, error) {
+	return importPlayers(s.store, dir)
+}
 
-	return ioutil.WriteFile(playerFileName, data.Bytes(), 0644)
+// Close releases the resources held by a server that was never started.
+func (s *Server) Close() error {
+	return s.db.Close()
 }
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gothyra/thyra/area"
+
+	"github.com/jpillora/ansi"
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// Values of session_policy.
+const (
+	sessionTakeover = "takeover"
+	sessionReject   = "reject"
+)
+
+// godConnect puts a client that logged in into the game. If the player is
+// already online, the new client continues the existing session: a linkdead
+// session is reattached, a live one is taken over or the login is rejected
+// depending on the session policy.
+func (s *Server) godConnect(c *Client, roomsMap map[string]map[string][][]area.Cube) {
+	s.Lock()
+	existing := s.onlineClients[c.Name]
+	if existing == nil {
+		s.onlineClients[c.Name] = c
+		s.Unlock()
+		return
+	}
+	if !existing.linkdead && s.config.SessionPolicy == sessionReject {
+		s.Unlock()
+		log.Info(fmt.Sprintf("[%s] rejected, already online.", c.Name))
+		c.writeString(fmt.Sprintf("%s is already playing from another connection.\r\n", c.Name))
+		s.idPool <- c.id
+		c.stop()
+		return
+	}
+	// Continue in the world where the existing session is.
+	c.Player = existing.Player
+	c.messages = existing.messages
+	c.replyTo = existing.replyTo
+	s.onlineClients[c.Name] = c
+	s.Unlock()
+
+	s.idPool <- existing.id
+	msg := fmt.Sprintf("%s has reconnected.\n", c.Player.Nickname)
+	if existing.linkdead {
+		log.Info(fmt.Sprintf("[%s] reattached to a linkdead session.", c.Name))
+	} else {
+		log.Info(fmt.Sprintf("[%s] took over the session from %s.", c.Name, existing.sshConn.RemoteAddr()))
+		existing.conn.Write(ansi.EraseScreen)
+		existing.writeString("\r\nYour session was taken over by a new login.\r\n")
+		existing.stop()
+		return
+	}
+
+	others := s.OnlineClientsGetByRoom(c.Player.Area, c.Player.Room)
+	var room []Client
+	for _, other := range others {
+		if other.Name != c.Name {
+			room = append(room, other)
+		}
+	}
+	godMessage(room, msgRoom, msg)
+	s.godPrintRoom(room, roomsMap)
+}
+
+// godLinkdead keeps a client that lost its connection in the world so that
+// the player can reconnect to it. Without a linkdead timeout, the client is
+// disconnected right away.
+func (s *Server) godLinkdead(c *Client, roomsMap map[string]map[string][][]area.Cube) {
+	if s.config.LinkdeadTimeout.Duration == 0 {
+		s.godDisconnect(c, roomsMap)
+		return
+	}
+
+	s.Lock()
+	if s.onlineClients[c.Name] != c || c.linkdead {
+		s.Unlock()
+		return
+	}
+	c.linkdead = true
+	c.linkdeadSince = time.Now()
+	s.Unlock()
+
+	log.Info(fmt.Sprintf("[%s] lost the connection.", c.Name))
+	c.stop()
+	s.autosave.markDirty(c.Player)
+
+	room := s.OnlineClientsGetByRoom(c.Player.Area, c.Player.Room)
+	godMessage(room, msgRoom, fmt.Sprintf("%s lost the connection.", c.Player.Nickname))
+	s.godPrintRoom(room, roomsMap)
+}
+
+// godReapLinkdead disconnects the clients that did not reconnect in time.
+func (s *Server) godReapLinkdead(roomsMap map[string]map[string][][]area.Cube) {
+	var expired []*Client
+	s.RLock()
+	for _, c := range s.onlineClients {
+		if c.linkdead && time.Since(c.linkdeadSince) >= s.config.LinkdeadTimeout.Duration {
+			expired = append(expired, c)
+		}
+	}
+	s.RUnlock()
+
+	for _, c := range expired {
+		s.godDisconnect(c, roomsMap)
+	}
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gothyra/thyra/area"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// countdown warns every online player that the server is about to stop.
+// Another signal cuts it short.
+func (s *Server) countdown(signals <-chan os.Signal) {
+	left := int(s.config.ShutdownCountdown.Seconds())
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for ; left > 0; left-- {
+		switch {
+		case left == 1:
+			s.broadcast("The server is shutting down in 1 second.")
+		case left%10 == 0 || left <= 5:
+			s.broadcast(fmt.Sprintf("The server is shutting down in %d seconds.", left))
+		}
+		select {
+		case <-ticker.C:
+		case <-signals:
+			log.Warn("Skipping the shutdown countdown.")
+			left = 0
+		}
+	}
+	s.broadcast("The server is shutting down now.")
+}
+
+// broadcast shows msg to every online player. It gives up if God is busy so
+// that a stuck game loop cannot hold up the shutdown.
+func (s *Server) broadcast(msg string) {
+	select {
+	case s.Events <- Event{Type: EventAdmin, Payload: AdminEvent{Broadcast: msg}}:
+	case <-time.After(time.Second):
+		log.Warn(fmt.Sprintf("Cannot broadcast %q", msg))
+	}
+}
+
+// shutdown disconnects every online client the same way as when they quit,
+// then stops God and the client goroutines. Players God could not disconnect
+// in time are saved once it has stopped. It waits up to the shutdown timeout.
+func (s *Server) shutdown(stopCh chan struct{}, godWg, wg *sync.WaitGroup) {
+	deadline := time.Now().Add(s.config.ShutdownTimeout.Duration)
+
+	s.RLock()
+	var online []*Client
+	for _, c := range s.onlineClients {
+		online = append(online, c)
+	}
+	s.RUnlock()
+
+	timeout := time.After(time.Until(deadline))
+	s.saveBeforeShutdown(timeout)
+disconnect:
+	for _, c := range online {
+		select {
+		case s.Events <- Event{Type: EventDisconnect, Client: c, Payload: DisconnectEvent{}}:
+		case <-timeout:
+			log.Error("God did not disconnect the clients in time.")
+			break disconnect
+		}
+	}
+
+	close(stopCh)
+	if !waitTimeout(godWg, time.Until(deadline)) {
+		log.Error("God did not stop in time, saving players anyway.")
+	}
+	s.saveOnlinePlayers()
+	for _, c := range online {
+		c.stop()
+	}
+
+	if !waitTimeout(wg, time.Until(deadline)) {
+		log.Error(fmt.Sprintf("Connections did not close within %v.", s.config.ShutdownTimeout.Duration))
+	}
+}
+
+// saveBeforeShutdown has God save the online players while they are still
+// connected, and tells them once it is done.
+func (s *Server) saveBeforeShutdown(timeout <-chan time.Time) {
+	reply := make(chan error, 1)
+	select {
+	case s.Events <- Event{Type: EventAdmin, Payload: AdminEvent{Save: true}, Reply: reply}:
+	case <-timeout:
+		log.Error("God did not save the players in time.")
+		return
+	}
+	select {
+	case err := <-reply:
+		if err != nil {
+			s.broadcast("Your progress could not be saved.")
+			return
+		}
+		s.broadcast("Your progress has been saved.")
+	case <-timeout:
+		log.Error("God did not save the players in time.")
+	}
+}
+
+// saveOnlinePlayers saves every online player in a single transaction. If
+// that fails, the players are saved one by one so that one bad record does
+// not cost everyone their progress. It returns an error naming the players
+// that could not be saved.
+func (s *Server) saveOnlinePlayers() error {
+	var players []area.Player
+	for _, c := range s.OnlineClients() {
+		if !c.Player.Guest {
+			players = append(players, *c.Player)
+		}
+	}
+	if len(players) == 0 {
+		return nil
+	}
+	err := s.store.Save(players...)
+	if err == nil {
+		log.Info(fmt.Sprintf("Saved %d online players.", len(players)))
+		return nil
+	}
+	log.Error(fmt.Sprintf("Cannot save online players: %v", err))
+	var failed []string
+	for _, player := range players {
+		if err := s.store.Save(player); err != nil {
+			log.Error(fmt.Sprintf("Cannot save player %q: %v", player.Nickname, err))
+			failed = append(failed, player.Nickname)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("cannot save %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// waitTimeout waits for wg and reports whether it finished within d.
+func waitTimeout(wg *sync.WaitGroup, d time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"sort"
+	"time"
+
+	"github.com/gothyra/thyra/area"
+	"github.com/gothyra/thyra/game"
+)
+
+// dayPhase is the time of day in the game. A game day has four phases of the
+// same length.
+type dayPhase int
+
+const (
+	phaseDawn dayPhase = iota
+	phaseDay
+	phaseDusk
+	phaseNight
+)
+
+var dayPhaseMessages = map[dayPhase]string{
+	phaseDawn:  "The sun rises.",
+	phaseDay:   "It is noon.",
+	phaseDusk:  "The sun sets.",
+	phaseNight: "Night falls.",
+}
+
+// weathers are the kinds of weather of an area, with what players see when
+// the weather turns so.
+var weathers = []string{
+	"The sky clears up.",
+	"Clouds gather overhead.",
+	"It starts to rain.",
+	"A cold wind picks up.",
+}
+
+// world is the state of the game that is not saved with the players. It
+// belongs to God.
+type world struct {
+	phase   dayPhase
+	weather map[string]int // by area, an index in weathers
+	npcs    []*npc
+	fights  map[string]*encounter // by the key of the fighters
+	// dice roll the weather and what NPCs do, and seed the dice of each
+	// encounter.
+	dice game.Dice
+	// fightDice makes the dice of an encounter from their seed.
+	fightDice func(seed int64) game.Dice
+}
+
+// newWorld places the NPCs of the areas. Everything random in the world is
+// rolled with dice seeded with seed.
+func newWorld(areas map[string]area.Area, seed int64) *world {
+	w := &world{
+		weather:   make(map[string]int),
+		fights:    make(map[string]*encounter),
+		dice:      game.NewDice(seed),
+		fightDice: game.NewDice,
+	}
+	for _, areaName := range areaNames(areas) {
+		a := areas[areaName]
+		for _, roomName := range roomNames(a.Rooms) {
+			room := a.Rooms[roomName]
+			for _, n := range room.NPCs {
+				w.npcs = append(w.npcs, newNPC(a.Name, room.Name, len(w.npcs), n))
+			}
+		}
+	}
+	return w
+}
+
+// areaNames returns the names of the areas in order, so that the same seed
+// rolls the same world: Go maps are iterated in a random order.
+func areaNames(areas map[string]area.Area) []string {
+	names := make([]string, 0, len(areas))
+	for name := range areas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// roomNames returns the names of the rooms in order, like areaNames.
+func roomNames(rooms map[string]area.Room) []string {
+	names := make([]string, 0, len(rooms))
+	for name := range rooms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// godScheduleWorld registers the timers that make the world go on by itself.
+func (s *Server) godScheduleWorld(roomsMap map[string]map[string][][]area.Cube) {
+	w := s.world
+
+	s.scheduler.every("linkdead", s.config.TickInterval.Duration, func(time.Time) {
+		s.godReapLinkdead(roomsMap)
+	})
+	s.scheduler.every("regen", s.config.RegenInterval.Duration, func(time.Time) {
+		s.godRegen(roomsMap)
+	})
+	s.scheduler.every("daylight", s.config.DayLength.Duration/4, func(time.Time) {
+		s.godNextPhase(w, roomsMap)
+	})
+	s.scheduler.every("npc actions", s.config.NPCActionInterval.Duration, func(time.Time) {
+		s.godNPCActions(roomsMap)
+	})
+	s.scheduler.every("area reset", s.config.AreaReset.Duration, func(time.Time) {
+		s.godResetAreas(roomsMap)
+	})
+}
+
+// godRegen heals one hit point of every player and NPC that is hurt and not
+// fighting.
+func (s *Server) godRegen(roomsMap map[string]map[string][][]area.Cube) {
+	for _, n := range s.world.npcs {
+		if n.Damage > 0 && s.world.fights[n.key] == nil {
+			n.Damage--
+		}
+	}
+
+	var healed []Client
+	for _, c := range s.OnlineClients() {
+		if c.Player.Damage > 0 && s.world.fights[c.Player.Nickname] == nil {
+			c.Player.Damage--
+			s.autosave.markDirty(c.Player)
+			healed = append(healed, c)
+		}
+	}
+	s.godPrintAll(healed, roomsMap)
+}
+
+// godNextPhase moves the game to the next time of the day and lets the weather
+// of each area change, telling the players about it.
+func (s *Server) godNextPhase(w *world, roomsMap map[string]map[string][][]area.Cube) {
+	w.phase = (w.phase + 1) % 4
+	clients := s.OnlineClients()
+	godMessage(clients, msgRoom, dayPhaseMessages[w.phase])
+
+	for _, name := range areaNames(s.Areas) {
+		next := w.dice.Roll(len(weathers)) - 1
+		if next == w.weather[name] {
+			continue
+		}
+		w.weather[name] = next
+		godMessage(s.onlineClientsInArea(name), msgRoom, weathers[next])
+	}
+	s.godPrintAll(clients, roomsMap)
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gothyra/thyra/area"
+	"github.com/gothyra/thyra/game"
+)
+
+func TestNewWorldSeeded(t *testing.T) {
+	rooms := func(names ...string) map[string]area.Room {
+		m := make(map[string]area.Room)
+		for _, name := range names {
+			m[name] = area.Room{Name: name, NPCs: []area.NPC{{Name: "Rat", Position: "1"}}}
+		}
+		return m
+	}
+	areas := map[string]area.Area{
+		"City":  {Name: "City", Rooms: rooms("Inn", "Market", "Landing", "Cellar")},
+		"Arena": {Name: "Arena", Rooms: rooms("Cage", "Pit")},
+		"Woods": {Name: "Woods", Rooms: rooms("Glade", "Path", "Cave")},
+	}
+	keys := func(w *world) []string {
+		var keys []string
+		for _, n := range w.npcs {
+			keys = append(keys, n.key)
+		}
+		return keys
+	}
+
+	expected := keys(newWorld(areas, 1))
+	for i := 0; i < 10; i++ {
+		if got := keys(newWorld(areas, 1)); !reflect.DeepEqual(got, expected) {
+			t.Fatalf("expected the same seed to place the same NPCs, got\n%v\nand\n%v", expected, got)
+		}
+	}
+	if expected[0] != "Arena/Cage/Rat#0" {
+		t.Errorf("expected the NPCs to be placed in the order of the areas and rooms, got %v", expected)
+	}
+}
+
+func TestNPCActions(t *testing.T) {
+	tests := []struct {
+		name string
+		rat  area.NPC
+		// bat stands in the way too.
+		bat      bool
+		fighting bool
+		rolls    []int
+
+		at      string // where the rat is afterwards
+		watched string // the last message of Mike
+	}{
+		{
+			name:    "action",
+			rat:     area.NPC{Actions: []string{"squeaks.", "sniffs the air."}},
+			rolls:   []int{2},
+			at:      "1",
+			watched: "Giant Rat sniffs the air.",
+		},
+		{
+			name:  "wander to a free cube",
+			rat:   area.NPC{Wander: true},
+			rolls: []int{1, 1},
+			at:    "5",
+		},
+		{
+			name:  "nowhere to wander",
+			rat:   area.NPC{Wander: true},
+			bat:   true,
+			rolls: []int{1},
+			at:    "1",
+		},
+		{
+			name:     "fighting",
+			rat:      area.NPC{Wander: true, Actions: []string{"squeaks."}},
+			fighting: true,
+			rolls:    []int{1}, // the seed of the fight
+			at:       "1",
+			watched:  "Bob attacks Giant Rat!",
+		},
+	}
+
+	for _, test := range tests {
+		rat := test.rat
+		rat.Name, rat.Position = "Giant Rat", "1"
+		npcs := []area.NPC{rat}
+		if test.bat {
+			npcs = append(npcs, area.NPC{Name: "Bat", Position: "5"})
+		}
+		ct := newCombatTest(t, npcs, 12, 5)
+		ct.s.world.dice = &scriptedDice{t: t, rolls: test.rolls}
+		bob, mike := ct.join("Bob", "2"), ct.join("Mike", "4")
+		if test.fighting {
+			ct.command(bob, "attack rat")
+		}
+
+		ct.s.godNPCActions(ct.roomsMap)
+
+		if at := ct.s.world.npcs[0].Position; at != test.at {
+			t.Errorf("%s: expected the rat at %s, got %s", test.name, test.at, at)
+		}
+		if got := lastMessage(mike); got != test.watched {
+			t.Errorf("%s: expected Mike's last message to be %q, got %q", test.name, test.watched, got)
+		}
+	}
+}
+
+func TestResetAreas(t *testing.T) {
+	tests := []struct {
+		name string
+		// defeated has Bob fell the rat first.
+		defeated bool
+		// moved and hurt are where the rat is and the damage it took.
+		moved  string
+		hurt   int
+		mikeAt string
+
+		at      string
+		watched string
+	}{
+		{
+			name:     "defeated NPCs come back",
+			defeated: true,
+			mikeAt:   "9",
+			at:       "4",
+			watched:  "Giant Rat appears.",
+		},
+		{
+			name:    "NPCs heal and go home",
+			moved:   "7",
+			hurt:    1,
+			mikeAt:  "9",
+			at:      "4",
+			watched: "",
+		},
+		{
+			name:   "home taken",
+			moved:  "7",
+			hurt:   1,
+			mikeAt: "4",
+			at:     "7",
+		},
+	}
+
+	for _, test := range tests {
+		rat := area.NPC{Name: "Giant Rat", Position: "4", PC: game.PC{HP: 2, AC: 10, STR: 10, DEX: 10, Weapon: "bite", Weapondie: 4}}
+		ct := newCombatTest(t, []area.NPC{rat}, 12, 5, 15, 3)
+		bob, mike := ct.join("Bob", "5"), ct.join("Mike", test.mikeAt)
+		n := ct.s.world.npcs[0]
+		if test.defeated {
+			ct.command(bob, "attack rat")
+			ct.rounds(1)
+		}
+		if test.moved != "" {
+			n.Position, n.Damage = test.moved, test.hurt
+		}
+
+		ct.s.godResetAreas(ct.roomsMap)
+		if n.dead || n.Damage != 0 || n.Position != test.at {
+			t.Errorf("%s: expected the rat alive and healed at %s, got dead %t with %d damage at %s", test.name, test.at, n.dead, n.Damage, n.Position)
+		}
+		if test.watched != "" && lastMessage(mike) != test.watched {
+			t.Errorf("%s: expected Mike's last message to be %q, got %q", test.name, test.watched, lastMessage(mike))
+		}
+
+		// A defeated NPC that came back does not come back again.
+		ct.wait(ct.s.config.CombatRound.Duration + ct.s.config.NPCRespawn.Duration)
+		if got := countMessages(mike, "Giant Rat appears."); test.defeated && got != 1 {
+			t.Errorf("%s: expected the rat to appear once, got %d times", test.name, got)
+		}
+	}
+}